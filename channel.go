@@ -1,7 +1,9 @@
 package pubsub
 
 import (
+	"context"
 	"runtime/debug"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -11,32 +13,127 @@ type channel struct {
 	hub         *hub
 	name        string
 	closed      chan bool
-	broadcast   chan interface{}
+	done        chan struct{}
+	broadcast   chan *message
 	subscribe   chan *sub
 	unsubscribe chan *sub
+	exec        chan func()
+	history     *ring
+	expire      *time.Timer // fires when history of channel without subscribers ages out
+	expireAt    time.Time
+	retained    *Envelope
+	meter       Meter
 	subs        map[*sub]struct{}
+	members     map[string]*presence
+	presenceEnd chan struct{} // closed when last presence event is published
+	groups      map[string][]*sub
+	cursors     map[string]int
 }
 
 func makeChannel(hub *hub, name string) *channel {
+	var history *ring
+	if hub.options.HistorySize > 0 && !IsTransient(name) {
+		history = newRing(hub.options.HistorySize, hub.options.HistoryMaxAge)
+	}
 	return &channel{
 		hub:         hub,
 		name:        name,
 		closed:      make(chan bool),
-		broadcast:   make(chan interface{}),
+		done:        make(chan struct{}),
+		broadcast:   make(chan *message),
 		subscribe:   make(chan *sub),
 		unsubscribe: make(chan *sub),
+		exec:        make(chan func()),
+		history:     history,
 		subs:        make(map[*sub]struct{}),
+		members:     make(map[string]*presence),
+		groups:      make(map[string][]*sub),
+		cursors:     make(map[string]int),
 	}
 }
 
-// Publish data to all subscribers.
-func (c *channel) Publish(data interface{}) {
-	go func() { c.broadcast <- data }()
+// Message to broadcast.
+type message struct {
+	env       *Envelope
+	retain    bool
+	receivers chan int
+}
+
+// Publish envelope to all subscribers, returns number of receivers.
+func (c *channel) Publish(ctx context.Context, env *Envelope, retain bool) (int, error) {
+	m := &message{
+		env:       env,
+		retain:    retain,
+		receivers: make(chan int, 1),
+	}
+	select {
+	case c.broadcast <- m:
+	case <-c.done:
+		return 0, ErrClosed
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	select {
+	case n := <-m.receivers:
+		return n, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
 }
 
 // Subscribe adds new receiver.
-func (c *channel) Subscribe(r *sub) {
-	go func() { c.subscribe <- r }()
+func (c *channel) Subscribe(ctx context.Context, r *sub) error {
+	select {
+	case c.subscribe <- r:
+		return nil
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// History runs given query on channel history.
+func (c *channel) History(ctx context.Context, q *historyQuery) ([]*Envelope, error) {
+	var list []*Envelope
+	err := c.Exec(ctx, func() {
+		list = q.run(c.history)
+	})
+	return list, err
+}
+
+// Retained returns retained message.
+func (c *channel) Retained(ctx context.Context) (*Envelope, error) {
+	var env *Envelope
+	err := c.Exec(ctx, func() {
+		env = c.getRetained()
+	})
+	return env, err
+}
+
+// ClearRetained removes retained message.
+func (c *channel) ClearRetained(ctx context.Context) error {
+	return c.Exec(ctx, func() {
+		c.retained = nil
+	})
+}
+
+// Exec runs given function within channel goroutine.
+func (c *channel) Exec(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	select {
+	case c.exec <- func() { fn(); close(done) }:
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Close channel.
@@ -51,18 +148,47 @@ func (c *channel) start() {
 	}
 
 	for {
+		expire := c.expiry()
 		select {
 
 		case sub := <-c.subscribe:
-			c.subs[sub] = struct{}{}
+			if c.add(sub) {
+				c.join(sub)
+				c.replay(sub)
+			}
 
 		case sub := <-c.unsubscribe:
-			delete(c.subs, sub)
-			close(sub.send)
+			// channel could be added back while request was pending
+			if !sub.has(c) && c.remove(sub) {
+				c.leave(sub)
+			}
+			if c.idle() {
+				c.release()
+				return
+			}
 
 		case msg := <-c.broadcast:
-			for sub := range c.subs {
-				sub.send <- msg
+			c.meter.Mark()
+			if c.history != nil {
+				c.history.push(msg.env)
+			}
+			if msg.retain {
+				c.retained = msg.env
+			}
+			msg.receivers <- c.deliver(msg.env)
+
+		case fn := <-c.exec:
+			fn()
+			if c.idle() {
+				c.release()
+				return
+			}
+
+		case <-expire:
+			c.expire = nil
+			if c.idle() {
+				c.release()
+				return
 			}
 
 		case <-c.closed:
@@ -72,7 +198,150 @@ func (c *channel) start() {
 	}
 }
 
+// Returns retained message unless it is expired.
+func (c *channel) getRetained() *Envelope {
+	if c.retained != nil && c.retained.Expired(time.Now()) {
+		c.retained = nil
+	}
+	return c.retained
+}
+
+// Reports whether channel has nothing to keep, so it could be released.
+func (c *channel) idle() bool {
+	return len(c.subs) == 0 && (c.history == nil || c.history.empty()) && c.getRetained() == nil
+}
+
+// Returns timer channel firing when history of channel without subscribers ages out,
+// nil if there is nothing to expire.
+func (c *channel) expiry() <-chan time.Time {
+	var at time.Time
+	var ok bool
+	if len(c.subs) == 0 && c.history != nil {
+		at, ok = c.history.expiresAt()
+	}
+	if !ok {
+		c.stopExpiry()
+		return nil
+	}
+	if c.expire == nil || !at.Equal(c.expireAt) {
+		c.stopExpiry()
+		c.expire = time.NewTimer(time.Until(at))
+		c.expireAt = at
+	}
+	return c.expire.C
+}
+
+func (c *channel) stopExpiry() {
+	if c.expire != nil {
+		c.expire.Stop()
+		c.expire = nil
+	}
+}
+
+// Removes idle channel from hub.
+func (c *channel) release() {
+	c.stopExpiry()
+	c.hub.remove(c)
+	close(c.done)
+}
+
+// Adds subscriber, called before start or within channel goroutine.
+// Returns false if subscriber is added already.
+func (c *channel) add(sub *sub) bool {
+	if _, ok := c.subs[sub]; ok {
+		return false
+	}
+	c.subs[sub] = struct{}{}
+	if group := sub.options.Group; len(group) > 0 {
+		c.groups[group] = append(c.groups[group], sub)
+	}
+	return true
+}
+
+// Delivers retained message and requested history to new subscriber before any live message.
+func (c *channel) replay(sub *sub) {
+	if len(sub.options.Group) > 0 {
+		return
+	}
+	var list []*Envelope
+	if c.history != nil && sub.options.HasReplay() {
+		q := &historyQuery{last: sub.options.Replay, since: sub.options.ReplaySince}
+		list = q.run(c.history)
+	}
+	if r := c.getRetained(); r != nil && !containsID(list, r.ID) {
+		sub.inbox.Push(r)
+	}
+	for _, env := range list {
+		sub.inbox.Push(env)
+	}
+}
+
+func containsID(list []*Envelope, id string) bool {
+	for _, env := range list {
+		if env.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes subscriber, returns false if it is not added.
+func (c *channel) remove(sub *sub) bool {
+	if _, ok := c.subs[sub]; !ok {
+		return false
+	}
+	delete(c.subs, sub)
+	group := sub.options.Group
+	if len(group) == 0 {
+		return true
+	}
+	members := c.groups[group]
+	for i, s := range members {
+		if s == sub {
+			members = append(members[:i], members[i+1:]...)
+			break
+		}
+	}
+	if len(members) == 0 {
+		delete(c.groups, group)
+		delete(c.cursors, group)
+		return true
+	}
+	c.groups[group] = members
+	return true
+}
+
+// Delivers envelope to every subscriber and to one member of each group,
+// returns number of receivers.
+func (c *channel) deliver(env *Envelope) int {
+	n := 0
+	for sub := range c.subs {
+		if len(sub.options.Group) > 0 {
+			continue
+		}
+		if sub.has(c) && sub.inbox.Push(env) {
+			n++
+		}
+	}
+	for group, members := range c.groups {
+		// round-robin, skip members which could not accept message
+		start := c.cursors[group]
+		for i := 0; i < len(members); i++ {
+			k := (start + i) % len(members)
+			sub := members[k]
+			if sub.has(c) && sub.inbox.Push(env) {
+				c.cursors[group] = k + 1
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
 func (c *channel) stop() {
+	c.stopExpiry()
+	close(c.done)
 	for s := range c.subs {
 		s.Close()
 	}
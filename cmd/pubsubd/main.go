@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -19,7 +20,10 @@ import (
 	_ "github.com/gocontrib/pubsub/nats"
 	_ "github.com/gocontrib/pubsub/redis"
 	"github.com/gocontrib/pubsub/sse"
+	_ "github.com/gocontrib/pubsub/wal"
 	"github.com/gorilla/handlers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -32,22 +36,29 @@ func opt(name, defval string) string {
 }
 
 var (
-	nats string = opt("NATS_URI", "nats:4222")
+	driver string = opt("PUBSUBD_DRIVER", "nats")
+	nats   string = opt("NATS_URI", "nats:4222")
+	data   string = opt("PUBSUBD_DATA", "data")
 )
 
 func main() {
 	addr := opt("PUBSUBD_ADDR", ":4302")
 
-	fmt.Printf("starting pubsub --addr %s --nats %s", addr, nats)
+	fmt.Printf("starting pubsub --addr %s --driver %s", addr, driver)
 
 	start := func() {
-		initHub(nats)
+		initHub()
 		startServer(addr)
 	}
 
 	stop := func() {
-		pubsub.Cleanup()
-		stopServer()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+		defer cancel()
+		// subscriptions are closed first to finish event streams
+		if err := pubsub.Shutdown(ctx); err != nil {
+			log.Errorf("pubsub shutdown failed: %+v", err)
+		}
+		stopServer(ctx)
 	}
 
 	die := make(chan bool)
@@ -64,11 +75,26 @@ func main() {
 	stop()
 }
 
-func initHub(nats string) {
-	err := pubsub.Init(pubsub.HubConfig{
-		"driver": "nats",
+func initHub() {
+	config := pubsub.HubConfig{
+		"driver": driver,
 		"url":    nats,
-	})
+		"codec":  opt("PUBSUBD_CODEC", ""),
+
+		"compression":           opt("PUBSUBD_COMPRESSION", ""),
+		"compression_threshold": opt("PUBSUBD_COMPRESSION_THRESHOLD", ""),
+
+		"signing_keys":   opt("PUBSUBD_SIGNING_KEYS", ""),
+		"signing_key":    opt("PUBSUBD_SIGNING_KEY", ""),
+		"signing_mode":   opt("PUBSUBD_SIGNING_MODE", ""),
+		"signing_window": opt("PUBSUBD_SIGNING_WINDOW", ""),
+	}
+	if driver == "wal" || driver == "file" {
+		config["dir"] = data
+		config["retention"] = opt("PUBSUBD_RETENTION", "")
+		config["fsync"] = opt("PUBSUBD_FSYNC", "")
+	}
+	err := pubsub.Init(config)
 	if err != nil {
 		log.Fatalf("cannot initialize hub")
 	}
@@ -90,9 +116,19 @@ func startServer(addr string) {
 	}
 }
 
-func stopServer() {
+func stopServer(ctx context.Context) {
 	fmt.Println("shutting down")
-	server.Shutdown(nil)
+	server.Shutdown(ctx)
+}
+
+// shutdownTimeout limits graceful shutdown, configured by PUBSUBD_SHUTDOWN_TIMEOUT.
+func shutdownTimeout() time.Duration {
+	d, err := time.ParseDuration(opt("PUBSUBD_SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		log.Errorf("invalid PUBSUBD_SHUTDOWN_TIMEOUT: %v", err)
+		return 30 * time.Second
+	}
+	return d
 }
 
 func makeHandler() http.Handler {
@@ -116,6 +152,7 @@ func makeHandler() http.Handler {
 
 	r.Group(eventAPI)
 	r.Group(healthAPI)
+	r.Group(metricsAPI)
 
 	return r
 }
@@ -127,6 +164,10 @@ func eventAPI(r chi.Router) {
 }
 
 func healthAPI(r chi.Router) {
+	if driver != "nats" {
+		return
+	}
+
 	h := health.New()
 
 	natsURL, err := url.Parse(nats)
@@ -160,6 +201,17 @@ func healthAPI(r chi.Router) {
 	r.Get("/api/pubsub/health", healthHandlers.NewJSONHandlerFunc(h, nil))
 }
 
+func metricsAPI(r chi.Router) {
+	if err := pubsub.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		log.Fatalf("unable to register metrics: %v", err)
+	}
+	if err := sse.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		log.Fatalf("unable to register metrics: %v", err)
+	}
+
+	r.Get("/metrics", promhttp.Handler().ServeHTTP)
+}
+
 func Logger(next http.Handler) http.Handler {
 	return handlers.LoggingHandler(os.Stdout, next)
 }
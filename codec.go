@@ -0,0 +1,262 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
+)
+
+// HeaderContentType names codec of message payload, JSON is used if it is empty.
+const HeaderContentType = "content-type"
+
+// Content types of builtin codecs.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgpack  = "application/msgpack"
+	ContentTypeCBOR     = "application/cbor"
+	ContentTypeGob      = "application/x-gob"
+	ContentTypeProtobuf = "application/protobuf"
+	ContentTypeRaw      = "application/octet-stream"
+)
+
+// Codec encodes and decodes message payloads.
+type Codec interface {
+	// ContentType identifies codec in message headers.
+	ContentType() string
+	// Marshal encodes given value.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into value pointed by v.
+	Unmarshal(data []byte, v interface{}) error
+	// Decode decodes payload of unknown type into generic value.
+	Decode(data []byte) (interface{}, error)
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = make(map[string]Codec)
+)
+
+func init() {
+	RegisterCodec(jsonCodec{}, "json")
+	RegisterCodec(msgpackCodec{}, "msgpack", "application/x-msgpack")
+	RegisterCodec(cborCodec{}, "cbor")
+	RegisterCodec(gobCodec{}, "gob")
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	RegisterCodec(protobufCodec{}, "protobuf", "proto", "application/x-protobuf")
+	RegisterCodec(rawCodec{}, "raw")
+}
+
+// RegisterCodec makes codec available by its content type and given aliases.
+func RegisterCodec(codec Codec, aliases ...string) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	codecs[strings.ToLower(codec.ContentType())] = codec
+	for _, name := range aliases {
+		codecs[strings.ToLower(name)] = codec
+	}
+}
+
+// GetCodec returns codec registered with given content type or alias, nil if it is unknown.
+func GetCodec(name string) Codec {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	return codecs[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// Returns codec of envelope payload.
+func envelopeCodec(env *Envelope) (Codec, error) {
+	ct := env.Header(HeaderContentType)
+	if len(ct) == 0 {
+		return jsonCodec{}, nil
+	}
+	if c := GetCodec(ct); c != nil {
+		return c, nil
+	}
+	return nil, fmt.Errorf("pubsub: unknown content type %q", ct)
+}
+
+// UseCodec middleware encodes published messages with given codec unless content type is set already.
+func UseCodec(codec Codec) Middleware {
+	return Middleware{
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, channels []string, env *Envelope, opts ...PublishOption) (PublishResult, error) {
+				if len(env.Header(HeaderContentType)) == 0 {
+					env.SetHeader(HeaderContentType, codec.ContentType())
+				}
+				return next(ctx, channels, env, opts...)
+			}
+		},
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                        { return ContentTypeJSON }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Decode(data []byte) (interface{}, error)    { return decodeJSON(data) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string                        { return ContentTypeMsgpack }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+func (msgpackCodec) Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return stringKeys(v), nil
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string                        { return ContentTypeCBOR }
+func (cborCodec) Marshal(v interface{}) ([]byte, error)      { return cbor.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
+
+func (cborCodec) Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := cbor.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return stringKeys(v), nil
+}
+
+// Gob codec encodes payload as interface value,
+// so concrete types of payloads must be registered with gob.Register.
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return ContentTypeGob }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gobCodec) Unmarshal(data []byte, v interface{}) error {
+	value, err := c.Decode(data)
+	if err != nil {
+		return err
+	}
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("pubsub: gob decode to non-pointer %T", v)
+	}
+	src := reflect.ValueOf(value)
+	if !src.IsValid() {
+		ptr.Elem().Set(reflect.Zero(ptr.Elem().Type()))
+		return nil
+	}
+	if !src.Type().AssignableTo(ptr.Elem().Type()) {
+		return fmt.Errorf("pubsub: gob cannot decode %T to %T", value, v)
+	}
+	ptr.Elem().Set(src)
+	return nil
+}
+
+func (gobCodec) Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Protobuf codec encodes values implementing proto.Message,
+// payloads of unknown type are decoded as raw bytes passed as is when message is relayed.
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	if data, ok := v.([]byte); ok {
+		return data, nil
+	}
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("pubsub: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("pubsub: %T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (protobufCodec) Decode(data []byte) (interface{}, error) {
+	return append([]byte(nil), data...), nil
+}
+
+// Raw codec passes byte slices and strings as is, payloads are decoded as bytes.
+// Encoded payload follows envelope header like payloads of other codecs.
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string { return ContentTypeRaw }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case []byte:
+		return t, nil
+	case string:
+		return []byte(t), nil
+	}
+	return nil, fmt.Errorf("pubsub: raw codec cannot marshal %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch t := v.(type) {
+	case *[]byte:
+		*t = append((*t)[:0], data...)
+	case *string:
+		*t = string(data)
+	default:
+		return fmt.Errorf("pubsub: raw codec cannot unmarshal to %T", v)
+	}
+	return nil
+}
+
+func (rawCodec) Decode(data []byte) (interface{}, error) {
+	return append([]byte(nil), data...), nil
+}
+
+// Converts maps with arbitrary keys to JSON compatible maps with string keys.
+func stringKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = stringKeys(val)
+		}
+		return m
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = stringKeys(val)
+		}
+		return t
+	case []interface{}:
+		for i, val := range t {
+			t[i] = stringKeys(val)
+		}
+		return t
+	}
+	return v
+}
@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/snappy"
+)
+
+// DefaultCompressionThreshold is minimal size of compressed message in bytes.
+const DefaultCompressionThreshold = 1024
+
+// MaxDecompressedSize limits size of decompressed message in bytes,
+// larger messages are rejected with ErrMessageTooLarge.
+var MaxDecompressedSize = 64 << 20
+
+// ErrMessageTooLarge is returned when decompressed message exceeds MaxDecompressedSize.
+var ErrMessageTooLarge = errors.New("pubsub: decompressed message is too large")
+
+// Compression of encoded messages larger than threshold,
+// compressed messages are detected by DecodeEnvelope automatically.
+type Compression struct {
+	Algorithm string // gzip or snappy, compression is disabled if empty
+	Threshold int    // minimal size of compressed message
+}
+
+// CompressionConfig reads compression and compression_threshold properties of hub config.
+func CompressionConfig(config HubConfig) (Compression, error) {
+	c := Compression{
+		Algorithm: strings.ToLower(strings.TrimSpace(config.GetString("compression", ""))),
+		Threshold: config.GetInt("compression_threshold", DefaultCompressionThreshold),
+	}
+	if c.Algorithm == "none" {
+		c.Algorithm = ""
+	}
+	if len(c.Algorithm) > 0 && compressorByName(c.Algorithm) == nil {
+		return c, fmt.Errorf("unknown compression: %s", c.Algorithm)
+	}
+	return c, nil
+}
+
+// EncodeEnvelope encodes envelope compressing it if it is large enough.
+func (c Compression) EncodeEnvelope(env *Envelope) ([]byte, error) {
+	data, err := EncodeEnvelope(env)
+	if err != nil || len(c.Algorithm) == 0 || len(data) < c.Threshold {
+		return data, err
+	}
+	z := compressorByName(c.Algorithm)
+	if z == nil {
+		return nil, fmt.Errorf("unknown compression: %s", c.Algorithm)
+	}
+	out, err := z.compress(data)
+	if err != nil {
+		codecErrors.WithLabelValues("marshal").Inc()
+		return nil, err
+	}
+	if len(out)+len(compressedMagic)+2 >= len(data) {
+		// incompressible message
+		return data, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(compressedMagic) + 2 + len(out))
+	buf.Write(compressedMagic)
+	buf.WriteByte(z.id)
+	buf.WriteByte('\n')
+	buf.Write(out)
+	return buf.Bytes(), nil
+}
+
+// Compressed message is magic prefix, compressor id, new line and compressed envelope.
+var compressedMagic = []byte("PSZ")
+
+type compressor struct {
+	id         byte
+	name       string
+	compress   func(data []byte) ([]byte, error)
+	decompress func(data []byte) ([]byte, error)
+}
+
+var compressors = []compressor{
+	{'g', "gzip", gzipCompress, gzipDecompress},
+	{'s', "snappy", snappyCompress, snappyDecompress},
+}
+
+func compressorByName(name string) *compressor {
+	for i := range compressors {
+		if compressors[i].name == name {
+			return &compressors[i]
+		}
+	}
+	return nil
+}
+
+// Decompresses message if it is compressed.
+func decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, compressedMagic) {
+		return data, nil
+	}
+	data = data[len(compressedMagic):]
+	if len(data) < 2 || data[1] != '\n' {
+		return nil, errBadEnvelope
+	}
+	for _, z := range compressors {
+		if z.id == data[0] {
+			return z.decompress(data[2:])
+		}
+	}
+	return nil, fmt.Errorf("pubsub: unknown compression %q", data[0])
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gzipDecompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err = ioutil.ReadAll(io.LimitReader(r, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedSize {
+		return nil, ErrMessageTooLarge
+	}
+	return data, nil
+}
+
+func snappyCompress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func snappyDecompress(data []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxDecompressedSize {
+		return nil, ErrMessageTooLarge
+	}
+	return snappy.Decode(nil, data)
+}
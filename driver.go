@@ -60,5 +60,5 @@ func RegisterDriver(d Driver, knownNames ...string) {
 	for _, k := range knownNames {
 		drivers[strings.ToLower(k)] = d
 	}
-	log.Info("registered pubsub driver: %v", knownNames)
+	log.Infof("registered pubsub driver: %v", knownNames)
 }
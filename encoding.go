@@ -1,7 +1,9 @@
 package pubsub
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 
 	"github.com/gocontrib/log"
 )
@@ -11,13 +13,106 @@ func Marshal(value interface{}) ([]byte, error) {
 	return json.Marshal(value)
 }
 
-// Unmarshal message from byte array.
+// Unmarshal message from byte array, any JSON value is decoded as is,
+// data which is not JSON is returned as raw bytes.
 func Unmarshal(data []byte) (interface{}, error) {
-	var msg map[string]interface{}
-	err := json.Unmarshal(data, &msg)
-	if err != nil {
+	if !json.Valid(data) {
+		return append([]byte(nil), data...), nil
+	}
+	return decodeJSON(data)
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	var msg interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
 		log.Errorf("json.Unmarshal failed: %+v", err)
 		return nil, err
 	}
 	return msg, nil
 }
+
+// Wire format of envelope is magic prefix, JSON header line and payload encoded by codec
+// named in content-type header. Payloads of registered message type are decoded into that type.
+var envelopeMagic = []byte("PS1\n")
+
+var errBadEnvelope = errors.New("pubsub: malformed envelope")
+
+// EncodeEnvelope encodes envelope to bytes sent by drivers.
+func EncodeEnvelope(env *Envelope) ([]byte, error) {
+	data, err := encodeEnvelope(env)
+	if err != nil {
+		codecErrors.WithLabelValues("marshal").Inc()
+	}
+	return data, err
+}
+
+func encodeEnvelope(env *Envelope) ([]byte, error) {
+	codec, err := envelopeCodec(env)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := codec.Marshal(env.Payload)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(envelopeMagic) + len(header) + 1 + len(payload))
+	buf.Write(envelopeMagic)
+	buf.Write(header)
+	buf.WriteByte('\n')
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+// DecodeEnvelope decodes envelope from bytes received by drivers.
+// Signature is stripped without verification, see Signer.
+// Compressed messages are decompressed, messages without envelope are decoded as bare payload,
+// see Unmarshal.
+func DecodeEnvelope(data []byte) (*Envelope, error) {
+	env, err := decodeEnvelope(data)
+	if err != nil {
+		codecErrors.WithLabelValues("unmarshal").Inc()
+	}
+	return env, err
+}
+
+func decodeEnvelope(data []byte) (*Envelope, error) {
+	data, err := decompress(unsign(data))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		payload, err := Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Envelope{Payload: payload}, nil
+	}
+
+	data = data[len(envelopeMagic):]
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, errBadEnvelope
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(data[:i], &env); err != nil {
+		log.Errorf("json.Unmarshal failed: %+v", err)
+		return nil, err
+	}
+
+	codec, err := envelopeCodec(&env)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decodePayload(&env, codec, data[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	env.Payload = payload
+	return &env, nil
+}
@@ -0,0 +1,260 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Headers of encrypted messages.
+const (
+	HeaderEncryptionKey        = "encryption-key"         // id of key payload is encrypted with
+	HeaderEncryptedContentType = "encrypted-content-type" // content type of payload before encryption
+)
+
+// ErrUnknownKey is returned when message is encrypted with key missing in keyring.
+var ErrUnknownKey = errors.New("pubsub: unknown encryption key")
+
+// Keyring holds AES keys by id, messages are encrypted with current key
+// and decrypted with any key, so old keys are kept until their messages are consumed.
+type Keyring struct {
+	mutex   sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewKeyring creates keyring with given current key.
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	if err := k.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add adds key used to decrypt messages, key size is 16, 24 or 32 bytes.
+func (k *Keyring) Add(id string, key []byte) error {
+	if len(id) == 0 {
+		return errors.New("pubsub: empty key id")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keys[id] = aead
+	return nil
+}
+
+// Rotate adds key and makes it current, previous keys still decrypt messages.
+func (k *Keyring) Rotate(id string, key []byte) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.current = id
+	return nil
+}
+
+// Remove removes retired key, current key could not be removed.
+func (k *Keyring) Remove(id string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if id == k.current {
+		return fmt.Errorf("pubsub: key %s is current", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Current returns id of current key.
+func (k *Keyring) Current() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current
+}
+
+// Encrypt seals data with current key, returns id of the key.
+// Nonce is prepended to sealed data, additional data is authenticated but not encrypted.
+func (k *Keyring) Encrypt(data, additional []byte) (string, []byte, error) {
+	k.mutex.RLock()
+	id, aead := k.current, k.keys[k.current]
+	k.mutex.RUnlock()
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return id, aead.Seal(nonce, nonce, data, additional), nil
+}
+
+// Decrypt opens data sealed with key of given id.
+func (k *Keyring) Decrypt(id string, data, additional []byte) ([]byte, error) {
+	k.mutex.RLock()
+	aead, ok := k.keys[id]
+	k.mutex.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errBadEnvelope
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additional)
+}
+
+// Encryption middleware encrypts payloads of messages published to channels matching given patterns,
+// all channels if no pattern is given, and decrypts received messages.
+// Payload is marshaled by its codec and encrypted with AES-GCM bound to message id, channel,
+// content and message types, key id is kept in encryption-key header. Messages which could not be decrypted are dropped.
+func Encryption(keyring *Keyring, patterns ...string) Middleware {
+	encrypted := func(channel string) bool {
+		return len(patterns) == 0 || matchAny(patterns, channel)
+	}
+	return Middleware{
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, channels []string, env *Envelope, opts ...PublishOption) (PublishResult, error) {
+				var secret, plain []string
+				for _, name := range channels {
+					if encrypted(name) {
+						secret = append(secret, name)
+					} else {
+						plain = append(plain, name)
+					}
+				}
+				if len(secret) == 0 {
+					return next(ctx, channels, env, opts...)
+				}
+				// ciphertext is bound to channel
+				sealed := make([]*Envelope, len(secret))
+				for i, name := range secret {
+					out, err := encryptEnvelope(keyring, env, name)
+					if err != nil {
+						return PublishResult{}, err
+					}
+					sealed[i] = out
+				}
+				results := make(map[string][]ChannelResult)
+				collect := func(r PublishResult) {
+					for _, c := range r.Channels {
+						results[c.Name] = append(results[c.Name], c)
+					}
+				}
+				var err error
+				if len(plain) > 0 {
+					var r PublishResult
+					r, err = next(ctx, plain, env, opts...)
+					collect(r)
+				}
+				for i, name := range secret {
+					r, errMore := next(ctx, []string{name}, sealed[i], opts...)
+					collect(r)
+					if err == nil {
+						err = errMore
+					}
+				}
+				// results in order of published channels
+				var result PublishResult
+				for _, name := range channels {
+					if list := results[name]; len(list) > 0 {
+						result.Channels = append(result.Channels, list[0])
+						results[name] = list[1:]
+					}
+				}
+				return result, err
+			}
+		},
+		Deliver: func(next DeliverFunc) DeliverFunc {
+			return func(env *Envelope) {
+				if len(env.Header(HeaderEncryptionKey)) == 0 {
+					if encrypted(env.Channel) {
+						log.Warnf("pubsub: dropped unencrypted message %s of %s", env.ID, env.Channel)
+						return
+					}
+					next(env)
+					return
+				}
+				if err := decryptEnvelope(keyring, env); err != nil {
+					log.Errorf("pubsub: cannot decrypt message %s of %s: %+v", env.ID, env.Channel, err)
+					return
+				}
+				next(env)
+			}
+		},
+	}
+}
+
+// Returns copy of envelope for given channel with encrypted payload.
+func encryptEnvelope(keyring *Keyring, env *Envelope, channel string) (*Envelope, error) {
+	codec, err := envelopeCodec(env)
+	if err != nil {
+		return nil, err
+	}
+	data, err := codec.Marshal(env.Payload)
+	if err != nil {
+		return nil, err
+	}
+	out := NewEnvelope(env)
+	out.Channel = channel
+	out.SetHeader(HeaderEncryptedContentType, codec.ContentType())
+	out.SetHeader(HeaderContentType, ContentTypeRaw)
+	id, sealed, err := keyring.Encrypt(data, additionalData(out))
+	if err != nil {
+		return nil, err
+	}
+	out.Payload = sealed
+	out.SetHeader(HeaderEncryptionKey, id)
+	return out, nil
+}
+
+// Returns data authenticated with encrypted payload,
+// so ciphertext could not be moved to other message or channel and its codecs could not be changed.
+func additionalData(env *Envelope) []byte {
+	return []byte(strings.Join([]string{
+		env.ID,
+		env.Channel,
+		env.Header(HeaderEncryptedContentType),
+		env.Header(HeaderMessageType),
+	}, "\n"))
+}
+
+// Replaces encrypted payload of envelope by decrypted one.
+func decryptEnvelope(keyring *Keyring, env *Envelope) error {
+	sealed, ok := env.Payload.([]byte)
+	if !ok {
+		return fmt.Errorf("pubsub: unexpected encrypted payload %T", env.Payload)
+	}
+	data, err := keyring.Decrypt(env.Header(HeaderEncryptionKey), sealed, additionalData(env))
+	if err != nil {
+		return err
+	}
+	ct := env.Header(HeaderEncryptedContentType)
+	codec := GetCodec(ct)
+	if codec == nil {
+		return fmt.Errorf("pubsub: unknown content type %q", ct)
+	}
+	plain := &Envelope{Headers: copyHeaders(env.Headers)}
+	plain.SetHeader(HeaderContentType, ct)
+	delete(plain.Headers, HeaderEncryptionKey)
+	delete(plain.Headers, HeaderEncryptedContentType)
+	payload, err := decodePayload(plain, codec, data)
+	if err != nil {
+		return err
+	}
+	env.Payload = payload
+	env.Headers = plain.Headers
+	return nil
+}
@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Envelope wraps message with delivery metadata.
+type Envelope struct {
+	ID      string            `json:"id,omitempty"`      // message id
+	Channel string            `json:"channel,omitempty"` // channel message is published to
+	Time    time.Time         `json:"time"`              // publish time
+	Headers map[string]string `json:"headers,omitempty"` // custom headers
+	TTL     time.Duration     `json:"ttl,omitempty"`     // time to live since publish time, unlimited if zero
+	Payload interface{}       `json:"-"`                 // message itself
+}
+
+// NewEnvelope wraps given message into envelope.
+// If message is envelope already its copy with missing ID and time is returned.
+// Given publish options set TTL and missing content and message types of envelope,
+// byte slices skip payload encoding and are sent with raw content type,
+// still wrapped into envelope header on the wire.
+func NewEnvelope(msg interface{}, opts ...PublishOption) *Envelope {
+	var env Envelope
+	switch m := msg.(type) {
+	case *Envelope:
+		env = *m
+		env.Headers = copyHeaders(m.Headers)
+	case Envelope:
+		env = m
+		env.Headers = copyHeaders(m.Headers)
+	default:
+		env.Payload = msg
+	}
+	if len(env.ID) == 0 {
+		env.ID = NewID()
+	}
+	if env.Time.IsZero() {
+		env.Time = time.Now().UTC()
+	}
+	options := MakePublishOptions(opts...)
+	if options.TTL > 0 {
+		env.TTL = options.TTL
+	}
+	if len(options.ContentType) > 0 && len(env.Header(HeaderContentType)) == 0 {
+		if c := GetCodec(options.ContentType); c != nil {
+			env.SetHeader(HeaderContentType, c.ContentType())
+		} else {
+			env.SetHeader(HeaderContentType, options.ContentType)
+		}
+	}
+	if len(env.Header(HeaderMessageType)) == 0 {
+		if name := options.MessageType; len(name) > 0 {
+			env.SetHeader(HeaderMessageType, name)
+		} else if name := TypeName(env.Payload); len(name) > 0 {
+			env.SetHeader(HeaderMessageType, name)
+		}
+	}
+	if _, raw := env.Payload.([]byte); raw && len(env.Header(HeaderContentType)) == 0 {
+		// bytes are sent as is
+		env.SetHeader(HeaderContentType, ContentTypeRaw)
+	}
+	return &env
+}
+
+// ExpiresAt returns time after which message is useless, zero if it never expires.
+func (e *Envelope) ExpiresAt() time.Time {
+	if e.TTL <= 0 {
+		return time.Time{}
+	}
+	return e.Time.Add(e.TTL)
+}
+
+// Expired reports whether message is expired at given time.
+func (e *Envelope) Expired(now time.Time) bool {
+	return e.TTL > 0 && now.After(e.Time.Add(e.TTL))
+}
+
+// ForChannel returns copy of envelope addressed to given channel.
+func (e *Envelope) ForChannel(name string) *Envelope {
+	env := *e
+	env.Channel = name
+	env.Headers = copyHeaders(e.Headers)
+	return &env
+}
+
+// Header returns value of given header.
+func (e *Envelope) Header(key string) string {
+	return e.Headers[key]
+}
+
+// SetHeader sets value of given header.
+func (e *Envelope) SetHeader(key, value string) {
+	if e.Headers == nil {
+		e.Headers = make(map[string]string)
+	}
+	e.Headers[key] = value
+}
+
+func copyHeaders(h map[string]string) map[string]string {
+	if h == nil {
+		return nil
+	}
+	c := make(map[string]string, len(h))
+	for k, v := range h {
+		c[k] = v
+	}
+	return c
+}
+
+// NewID generates random message id.
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
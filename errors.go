@@ -0,0 +1,12 @@
+package pubsub
+
+import "errors"
+
+var (
+	// ErrClosed is returned when operation is performed on closed hub or channel.
+	ErrClosed = errors.New("pubsub: closed")
+	// ErrUnsupported is returned when driver does not support requested feature.
+	ErrUnsupported = errors.New("pubsub: not supported by driver")
+	// ErrNoResponders is returned when request is not received by anyone.
+	ErrNoResponders = errors.New("pubsub: no responders")
+)
@@ -0,0 +1,492 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FilterExpr is compiled filter expression evaluated against message payload.
+//
+// Expression compares JSON paths of payload with literals, e.g.
+// resource_type == "task" && action != "GET" or payload.user.id >= 10 || tags[0] == "urgent".
+// Supported operators are ==, !=, <, <=, >, >=, &&, || and !, literals are
+// strings in double or single quotes, numbers, true, false and null.
+// Bare path is true if value is present and not false, zero or empty.
+type FilterExpr struct {
+	src  string
+	root node
+}
+
+// ParseFilter compiles filter expression.
+func ParseFilter(expr string) (*FilterExpr, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %s", p.peek().text)
+	}
+	return &FilterExpr{src: expr, root: root}, nil
+}
+
+func (f *FilterExpr) String() string {
+	return f.src
+}
+
+// Match reports whether payload of given envelope satisfies the filter.
+func (f *FilterExpr) Match(env *Envelope) bool {
+	return truthy(f.root.eval(normalize(env.Payload)))
+}
+
+// Converts payload to generic JSON value.
+func normalize(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, map[string]interface{}, []interface{}, string, bool, float64:
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// AST
+
+type node interface {
+	eval(doc interface{}) interface{}
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(doc interface{}) interface{} {
+	return n.value
+}
+
+// path segment is either string key or int index
+type path []interface{}
+
+func (n path) eval(doc interface{}) interface{} {
+	v := doc
+	for _, seg := range n {
+		switch k := seg.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[k]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || k >= len(a) {
+				return nil
+			}
+			v = a[k]
+		}
+	}
+	return v
+}
+
+type unary struct {
+	x node
+}
+
+func (n *unary) eval(doc interface{}) interface{} {
+	return !truthy(n.x.eval(doc))
+}
+
+type binary struct {
+	op   string
+	l, r node
+}
+
+func (n *binary) eval(doc interface{}) interface{} {
+	switch n.op {
+	case "&&":
+		return truthy(n.l.eval(doc)) && truthy(n.r.eval(doc))
+	case "||":
+		return truthy(n.l.eval(doc)) || truthy(n.r.eval(doc))
+	}
+	l, r := n.l.eval(doc), n.r.eval(doc)
+	switch n.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+	c, ok := compare(l, r)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	case []interface{}:
+		return len(t) > 0
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case bool:
+		y, ok := b.(bool)
+		return ok && x == y
+	}
+	return false
+}
+
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+	y, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// LEXER
+
+type tokenKind int
+
+const (
+	tokenOp tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenIdent
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("filter: unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokenString, s[i : j+1], i})
+			i = j + 1
+		case strings.ContainsRune("=!<>&|", rune(c)):
+			op := s[i : i+1]
+			if i+1 < len(s) {
+				if two := s[i : i+2]; two == "==" || two == "!=" || two == "<=" || two == ">=" || two == "&&" || two == "||" {
+					op = two
+				}
+			}
+			if op == "=" || op == "&" || op == "|" {
+				return nil, fmt.Errorf("filter: unexpected %q at %d", op, i)
+			}
+			tokens = append(tokens, token{tokenOp, op, i})
+			i += len(op)
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{tokenOp, s[i : i+1], i})
+			i++
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] == '.' || s[j] == 'e' || s[j] == 'E' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, s[i:j], i})
+			i = j
+		case isIdent(rune(c)):
+			j := i + 1
+			for j < len(s) && (isIdent(rune(s[j])) || s[j] == '.' || s[j] == '[' || s[j] == ']' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, s[i:j], i})
+			i = j
+		default:
+			return nil, fmt.Errorf("filter: unexpected %q at %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+func isIdent(c rune) bool {
+	return c == '_' || c == '$' || unicode.IsLetter(c)
+}
+
+// PARSER
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokenOp, text: "end of expression"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if p.done() || t.kind != tokenOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("filter: "+format, args...)
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return l, nil
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "||", l: l, r: r}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return l, nil
+		}
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "&&", l: l, r: r}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.accept("!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return l, nil
+	}
+	r, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &binary{op: op, l: l, r: r}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	if _, ok := p.accept("("); ok {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, p.errorf("expected ) instead of %s", p.peek().text)
+		}
+		return x, nil
+	}
+	if p.done() {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	switch t.kind {
+	case tokenString:
+		p.pos++
+		if t.text[0] == '\'' {
+			return &literal{singleQuoted.Replace(t.text[1 : len(t.text)-1])}, nil
+		}
+		v, err := strconv.Unquote(t.text)
+		if err != nil {
+			return nil, p.errorf("invalid string %s at %d", t.text, t.pos)
+		}
+		return &literal{v}, nil
+	case tokenNumber:
+		p.pos++
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s at %d", t.text, t.pos)
+		}
+		return &literal{v}, nil
+	case tokenIdent:
+		p.pos++
+		switch t.text {
+		case "true":
+			return &literal{true}, nil
+		case "false":
+			return &literal{false}, nil
+		case "null":
+			return &literal{nil}, nil
+		}
+		return parsePath(t)
+	}
+	return nil, p.errorf("unexpected %s at %d", t.text, t.pos)
+}
+
+var singleQuoted = strings.NewReplacer(`\'`, `'`, `\\`, `\`)
+
+// Parses path like user.tags[0].name.
+func parsePath(t token) (node, error) {
+	var n path
+	for _, part := range strings.Split(t.text, ".") {
+		name := part
+		var indexes []int
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			name = part[:i]
+			rest := part[i:]
+			for len(rest) > 0 {
+				end := strings.IndexByte(rest, ']')
+				if rest[0] != '[' || end < 0 {
+					return nil, fmt.Errorf("filter: invalid path %s at %d", t.text, t.pos)
+				}
+				k, err := strconv.Atoi(rest[1:end])
+				if err != nil || k < 0 {
+					return nil, fmt.Errorf("filter: invalid index in %s at %d", t.text, t.pos)
+				}
+				indexes = append(indexes, k)
+				rest = rest[end+1:]
+			}
+		}
+		if len(name) == 0 && (len(n) == 0 || len(indexes) == 0) {
+			return nil, fmt.Errorf("filter: invalid path %s at %d", t.text, t.pos)
+		}
+		if len(name) > 0 {
+			n = append(n, name)
+		}
+		for _, k := range indexes {
+			n = append(n, k)
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"strings"
+	"time"
+)
+
+// IsTransient reports whether messages of given channel are not kept in history,
+// i.e. it is reply or presence channel.
+func IsTransient(channel string) bool {
+	return strings.HasPrefix(channel, replyPrefix) || strings.HasPrefix(channel, PresencePrefix)
+}
+
+// Ring buffer of recent channel messages, bounded by count and age.
+// Buffer grows on demand up to size.
+// It is accessed only within channel goroutine.
+type ring struct {
+	items  []*Envelope
+	start  int
+	count  int
+	size   int
+	maxAge time.Duration
+}
+
+func newRing(size int, maxAge time.Duration) *ring {
+	return &ring{
+		size:   size,
+		maxAge: maxAge,
+	}
+}
+
+// Appends message evicting the oldest one if buffer is full.
+func (r *ring) push(env *Envelope) {
+	r.prune(time.Now())
+	if r.count == r.size {
+		r.items[r.start] = env
+		r.start = (r.start + 1) % len(r.items)
+		return
+	}
+	if r.count == len(r.items) {
+		r.grow()
+	}
+	r.items[(r.start+r.count)%len(r.items)] = env
+	r.count++
+}
+
+// Grows buffer up to ring size keeping message order.
+func (r *ring) grow() {
+	n := 2 * len(r.items)
+	if n < 8 {
+		n = 8
+	}
+	if n > r.size {
+		n = r.size
+	}
+	items := make([]*Envelope, n)
+	for i := 0; i < r.count; i++ {
+		items[i] = r.at(i)
+	}
+	r.items = items
+	r.start = 0
+}
+
+// Reports whether ring has no messages left after pruning.
+func (r *ring) empty() bool {
+	r.prune(time.Now())
+	return r.count == 0
+}
+
+// Returns time when the oldest message ages out, false if messages do not expire.
+func (r *ring) expiresAt() (time.Time, bool) {
+	if r.maxAge <= 0 || r.count == 0 {
+		return time.Time{}, false
+	}
+	return r.items[r.start].Time.Add(r.maxAge), true
+}
+
+// Evicts messages older than max age.
+func (r *ring) prune(now time.Time) {
+	if r.maxAge <= 0 {
+		return
+	}
+	for r.count > 0 && now.Sub(r.items[r.start].Time) > r.maxAge {
+		r.items[r.start] = nil
+		r.start = (r.start + 1) % len(r.items)
+		r.count--
+	}
+}
+
+func (r *ring) at(i int) *Envelope {
+	return r.items[(r.start+i)%len(r.items)]
+}
+
+// Returns up to n last messages in publish order, skipping expired ones.
+func (r *ring) last(n int) []*Envelope {
+	now := time.Now()
+	r.prune(now)
+	var list []*Envelope
+	for i := r.count - 1; i >= 0 && len(list) < n; i-- {
+		if env := r.at(i); !env.Expired(now) {
+			list = append(list, env)
+		}
+	}
+	// reverse to publish order
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// Returns messages published after message with given id,
+// or all messages if id is not found. Expired messages are skipped.
+func (r *ring) since(id string) []*Envelope {
+	now := time.Now()
+	r.prune(now)
+	start := 0
+	for i := r.count - 1; i >= 0; i-- {
+		if r.at(i).ID == id {
+			start = i + 1
+			break
+		}
+	}
+	var list []*Envelope
+	for i := start; i < r.count; i++ {
+		if env := r.at(i); !env.Expired(now) {
+			list = append(list, env)
+		}
+	}
+	return list
+}
+
+// Query of channel history.
+type historyQuery struct {
+	last  int
+	since string
+}
+
+func (q *historyQuery) run(r *ring) []*Envelope {
+	if r == nil {
+		return nil
+	}
+	if len(q.since) > 0 {
+		return r.since(q.since)
+	}
+	return r.last(q.last)
+}
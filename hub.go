@@ -1,77 +1,373 @@
 package pubsub
 
 import (
+	"context"
+	"sort"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
 )
 
 // NewHub creates new in-process pubsub hub.
-func NewHub() Hub {
+func NewHub(opts ...HubOption) Hub {
 	log.Info("use in-memory hub")
+	var options HubOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 	return &hub{
+		options:  options,
 		channels: make(map[string]*channel),
+		patterns: make(map[*sub][]string),
+		subs:     make(map[*sub]struct{}),
 	}
 }
 
 // Hub of pubsub channels.
 type hub struct {
 	sync.Mutex
+	options  HubOptions
+	closed   bool
+	closing  bool // set by Shutdown, rejects publishes and subscriptions
 	channels map[string]*channel
+	patterns map[*sub][]string
+	subs     map[*sub]struct{}
+
+	publishing sync.WaitGroup // pending publishes, waited by Shutdown
 }
 
 func (hub *hub) Close() error {
 	hub.Lock()
 	defer hub.Unlock()
+	hub.closed = true
 	for _, c := range hub.channels {
 		c.Close()
 	}
+	// channels are closed once
+	hub.channels = make(map[string]*channel)
 	return nil
 }
 
 // Publish data to given channel.
 func (hub *hub) Publish(channels []string, msg interface{}) {
+	// registered before returning, so Shutdown waits for it
+	if !hub.beginPublish() {
+		log.Errorf("publish failed: %+v", ErrClosed)
+		return
+	}
+	go func() {
+		defer hub.publishing.Done()
+		_, err := hub.publishContext(context.Background(), channels, msg)
+		if err != nil {
+			log.Errorf("publish failed: %+v", err)
+		}
+	}()
+}
+
+// PublishContext delivers data to given channels.
+func (hub *hub) PublishContext(ctx context.Context, channels []string, msg interface{}, opts ...PublishOption) (PublishResult, error) {
+	if !hub.beginPublish() {
+		return PublishResult{}, ErrClosed
+	}
+	defer hub.publishing.Done()
+	return hub.publishContext(ctx, channels, msg, opts...)
+}
+
+// Publishes message registered as pending publish.
+func (hub *hub) publishContext(ctx context.Context, channels []string, msg interface{}, opts ...PublishOption) (PublishResult, error) {
+	var result PublishResult
+	var options = MakePublishOptions(opts...)
+	var env = NewEnvelope(msg, opts...)
+	InjectTrace(ctx, env)
+	defer func() { ObservePublish("memory", result) }()
 	for _, name := range channels {
-		var cn = hub.getChannel(name)
-		cn.Publish(msg)
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		n, err := hub.publish(ctx, env.ForChannel(name), options.Retain)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return result, ctxErr
+		}
+		result.Add(name, n, err)
+	}
+	return result, result.Err()
+}
+
+// Registers pending publish unless hub is closed.
+func (hub *hub) beginPublish() bool {
+	hub.Lock()
+	defer hub.Unlock()
+	if hub.closed || hub.closing {
+		return false
+	}
+	hub.publishing.Add(1)
+	return true
+}
+
+func (hub *hub) publish(ctx context.Context, env *Envelope, retain bool) (int, error) {
+	for {
+		// channel keeping history or retained message is created even if nobody listens
+		history := hub.options.HistorySize > 0 && !IsTransient(env.Channel)
+		cn, err := hub.getChannel(env.Channel, retain || history)
+		if err != nil {
+			return 0, err
+		}
+		if cn == nil {
+			// nobody listens
+			return 0, nil
+		}
+		n, err := cn.Publish(ctx, env, retain)
+		if err == ErrClosed {
+			// idle channel has been just removed, try again
+			continue
+		}
+		return n, err
+	}
+}
+
+// History returns up to n last messages of given channel.
+func (hub *hub) History(ctx context.Context, channel string, n int) ([]*Envelope, error) {
+	return hub.history(ctx, channel, &historyQuery{last: n})
+}
+
+// HistorySince returns messages of given channel published after message with given id.
+func (hub *hub) HistorySince(ctx context.Context, channel string, id string) ([]*Envelope, error) {
+	return hub.history(ctx, channel, &historyQuery{since: id})
+}
+
+func (hub *hub) history(ctx context.Context, name string, q *historyQuery) ([]*Envelope, error) {
+	if hub.options.HistorySize <= 0 {
+		return nil, ErrUnsupported
+	}
+	cn, err := hub.getChannel(name, false)
+	if err != nil || cn == nil {
+		return nil, err
+	}
+	return cn.History(ctx, q)
+}
+
+// Retained returns retained message of given channel or nil.
+func (hub *hub) Retained(ctx context.Context, channel string) (*Envelope, error) {
+	cn, err := hub.getChannel(channel, false)
+	if err != nil || cn == nil {
+		return nil, err
+	}
+	env, err := cn.Retained(ctx)
+	if err == ErrClosed && !hub.isClosed() {
+		// idle channel has been just removed
+		return nil, nil
+	}
+	return env, err
+}
+
+// ClearRetained removes retained message of given channel.
+func (hub *hub) ClearRetained(ctx context.Context, channel string) error {
+	cn, err := hub.getChannel(channel, false)
+	if err != nil || cn == nil {
+		return err
+	}
+	err = cn.ClearRetained(ctx)
+	if err == ErrClosed && !hub.isClosed() {
+		// idle channel has been just removed
+		return nil
+	}
+	return err
+}
+
+// Channels returns channels having subscribers, history or retained message.
+func (hub *hub) Channels(ctx context.Context) ([]ChannelInfo, error) {
+	hub.Lock()
+	chans := make([]*channel, 0, len(hub.channels))
+	for _, cn := range hub.channels {
+		chans = append(chans, cn)
+	}
+	hub.Unlock()
+
+	list := make([]ChannelInfo, 0, len(chans))
+	for _, cn := range chans {
+		var info ChannelInfo
+		err := cn.Exec(ctx, func() {
+			info = ChannelInfo{
+				Name:        cn.name,
+				Subscribers: len(cn.subs),
+				Rate:        cn.meter.Rate(),
+			}
+		})
+		if err == ErrClosed {
+			// idle channel has been just removed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// Subscriptions returns open subscriptions.
+func (hub *hub) Subscriptions(ctx context.Context) ([]SubscriptionInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	hub.Lock()
+	defer hub.Unlock()
+	list := make([]SubscriptionInfo, 0, len(hub.subs))
+	for sub := range hub.subs {
+		info := SubscriptionInfo{
+			Pattern: sub.options.Pattern,
+			Group:   sub.options.Group,
+			Created: sub.created,
+		}
+		if sub.options.Pattern {
+			info.Channels = append([]string{}, hub.patterns[sub]...)
+		} else {
+			info.Channels = sub.channelNames()
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list, nil
+}
+
+func (hub *hub) isClosed() bool {
+	hub.Lock()
+	defer hub.Unlock()
+	return hub.closed
 }
 
 // Subscribe adds new receiver of events for given channel.
 func (hub *hub) Subscribe(channels []string) (Channel, error) {
-	var chans []*channel
-	for _, name := range channels {
-		chans = append(chans, hub.getChannel(name))
+	return hub.SubscribeContext(context.Background(), channels)
+}
+
+// SubscribeContext adds new receiver of events for given channel.
+func (hub *hub) SubscribeContext(ctx context.Context, channels []string, opts ...SubscribeOption) (Channel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	var sub = makeSub(chans)
-	for _, cn := range chans {
-		cn.Subscribe(sub)
+	var options = MakeSubscribeOptions(opts...)
+	if err := options.Err(); err != nil {
+		return nil, err
+	}
+	var sub = makeSub(hub, options)
+	hub.Lock()
+	if hub.closed || hub.closing {
+		hub.Unlock()
+		sub.observed()
+		sub.inbox.Close()
+		return nil, ErrClosed
+	}
+	hub.subs[sub] = struct{}{}
+	hub.Unlock()
+	if err := sub.subscribe(ctx, channels); err != nil {
+		sub.Close()
+		return nil, err
 	}
 	return sub, nil
 }
 
+// Adds patterns of given subscription, returns existing channels matching them.
+func (hub *hub) addPatterns(sub *sub, patterns []string) []*channel {
+	hub.Lock()
+	defer hub.Unlock()
+	hub.patterns[sub] = append(hub.patterns[sub], patterns...)
+	var chans []*channel
+	for name, cn := range hub.channels {
+		if matchAny(patterns, name) && sub.attach(cn) {
+			chans = append(chans, cn)
+		}
+	}
+	return chans
+}
+
+// Removes patterns of given subscription, returns channels not matching rest patterns.
+func (hub *hub) removePatterns(sub *sub, patterns []string) []*channel {
+	hub.Lock()
+	defer hub.Unlock()
+	var rest []string
+	for _, p := range hub.patterns[sub] {
+		if !contains(patterns, p) {
+			rest = append(rest, p)
+		}
+	}
+	hub.patterns[sub] = rest
+	return sub.detachIf(func(name string) bool {
+		return !matchAny(rest, name)
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if MatchPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetChannel gets or creates new pubsub channel.
-func (hub *hub) getChannel(name string) *channel {
+// Unless create is set, channel is created only if it matches some pattern subscription.
+func (hub *hub) getChannel(name string, create bool) (*channel, error) {
 	hub.Lock()
 	defer hub.Unlock()
+	if hub.closed {
+		return nil, ErrClosed
+	}
 	cn, ok := hub.channels[name]
 	if ok {
-		return cn
+		return cn, nil
+	}
+	if !create && !hub.matchPatterns(name) {
+		return nil, nil
 	}
 	cn = makeChannel(hub, name)
 	hub.channels[name] = cn
+	for sub, patterns := range hub.patterns {
+		if matchAny(patterns, name) && sub.attach(cn) {
+			cn.add(sub)
+		}
+	}
 	go cn.start()
-	return cn
+	return cn, nil
+}
+
+func (hub *hub) matchPatterns(name string) bool {
+	for _, patterns := range hub.patterns {
+		if matchAny(patterns, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unregisters given subscription and stops matching new channels for it.
+func (hub *hub) removeSub(sub *sub) {
+	hub.Lock()
+	defer hub.Unlock()
+	delete(hub.patterns, sub)
+	delete(hub.subs, sub)
 }
 
 // Removes given channel, called by Channel.Close.
 func (hub *hub) remove(cn *channel) {
 	hub.Lock()
 	defer hub.Unlock()
-	cn, ok := hub.channels[cn.name]
-	if !ok {
-		return
+	if hub.channels[cn.name] == cn {
+		delete(hub.channels, cn.name)
 	}
-	delete(hub.channels, cn.name)
-	return
 }
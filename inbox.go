@@ -0,0 +1,365 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy defines what to do when subscriber buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks delivery until subscriber reads buffered messages.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops oldest buffered message to free space for new one.
+	OverflowDropOldest
+	// OverflowDropNewest drops incoming message.
+	OverflowDropNewest
+	// OverflowDisconnect drops incoming message and closes slow subscription.
+	OverflowDisconnect
+)
+
+// DefaultBufferSize is default capacity of subscriber buffer.
+const DefaultBufferSize = 100
+
+// Inbox is bounded buffer of envelopes delivered to subscriber,
+// drivers use it to implement Read, ReadEnvelope and Dropped methods of Channel.
+type Inbox struct {
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	queue      []*Envelope
+	size       int
+	policy     OverflowPolicy
+	filter     *FilterExpr
+	disconnect func()
+	relay      bool // passes envelopes of another inbox, not counted as delivered
+	dropped    uint64
+	expired    uint64
+	stopped    bool
+	closed     bool
+	held       int  // envelopes taken from queue but not read by subscriber yet
+	forwarding bool // Read goroutine passes envelopes to values channel
+	evicting   bool // Push waits for held envelope to be dropped
+	evict      chan struct{}
+	out        chan *Envelope
+	done       chan struct{}
+	values     chan interface{}
+	valuesOnce sync.Once
+}
+
+// NewInbox creates inbox configured by given subscription options,
+// disconnect is called when OverflowDisconnect policy is triggered.
+func NewInbox(options SubscribeOptions, disconnect func()) *Inbox {
+	return newInbox(options, disconnect, false)
+}
+
+func newInbox(options SubscribeOptions, disconnect func(), relay bool) *Inbox {
+	size := options.BufferSize
+	if size < 1 {
+		size = 1
+	}
+	ib := &Inbox{
+		size:       size,
+		policy:     options.Overflow,
+		filter:     options.Filter,
+		disconnect: disconnect,
+		relay:      relay,
+		evict:      make(chan struct{}, 1),
+		out:        make(chan *Envelope),
+		done:       make(chan struct{}),
+	}
+	ib.cond = sync.NewCond(&ib.mutex)
+	go ib.pump()
+	return ib
+}
+
+// Push queues envelope for delivery applying overflow policy.
+// Returns false if envelope is dropped, expired, filtered out or inbox is stopped.
+func (ib *Inbox) Push(env *Envelope) bool {
+	if env.Expired(time.Now()) {
+		ib.expire()
+		return false
+	}
+	if ib.filter != nil && !ib.filter.Match(env) {
+		return false
+	}
+
+	ib.mutex.Lock()
+	defer ib.mutex.Unlock()
+
+	if ib.full() {
+		ib.removeExpired()
+	}
+
+	for !ib.stopped && ib.full() {
+		switch ib.policy {
+		case OverflowDropOldest:
+			if ib.held == 0 {
+				ib.queue[0] = nil
+				ib.queue = ib.queue[1:]
+				ib.drop()
+				continue
+			}
+			// oldest envelopes are held by pump or Read goroutine
+			ib.evicting = true
+			select {
+			case ib.evict <- struct{}{}:
+			default:
+				// request is pending already
+			}
+			ib.cond.Wait()
+		case OverflowDropNewest:
+			ib.drop()
+			return false
+		case OverflowDisconnect:
+			ib.drop()
+			ib.stopped = true
+			ib.cond.Broadcast()
+			if ib.disconnect != nil {
+				go ib.disconnect()
+			}
+			return false
+		default:
+			ib.cond.Wait()
+		}
+	}
+	if ib.evicting {
+		// held envelope is read meanwhile, withdraw request
+		ib.evicting = false
+		select {
+		case <-ib.evict:
+		default:
+		}
+	}
+	if ib.stopped {
+		return false
+	}
+
+	ib.queue = append(ib.queue, env)
+	ib.cond.Broadcast()
+	return true
+}
+
+// Reports whether buffer is full, envelopes held by pump are counted too,
+// called with locked mutex.
+func (ib *Inbox) full() bool {
+	return len(ib.queue)+ib.held >= ib.size
+}
+
+// Removes expired envelopes from queue, called with locked mutex.
+func (ib *Inbox) removeExpired() {
+	now := time.Now()
+	queue := ib.queue[:0]
+	for _, env := range ib.queue {
+		if env.Expired(now) {
+			ib.expire()
+			continue
+		}
+		queue = append(queue, env)
+	}
+	for i := len(queue); i < len(ib.queue); i++ {
+		ib.queue[i] = nil
+	}
+	ib.queue = queue
+}
+
+func (ib *Inbox) drop() {
+	atomic.AddUint64(&ib.dropped, 1)
+	droppedMessages.WithLabelValues("overflow").Inc()
+}
+
+func (ib *Inbox) expire() {
+	atomic.AddUint64(&ib.expired, 1)
+	droppedMessages.WithLabelValues("expired").Inc()
+}
+
+// Dropped returns number of envelopes dropped by overflow policy.
+func (ib *Inbox) Dropped() uint64 {
+	return atomic.LoadUint64(&ib.dropped)
+}
+
+// Expired returns number of envelopes dropped because their TTL elapsed.
+func (ib *Inbox) Expired() uint64 {
+	return atomic.LoadUint64(&ib.expired)
+}
+
+// Stop rejects new pushes, buffered envelopes are still delivered until Close.
+func (ib *Inbox) Stop() {
+	ib.mutex.Lock()
+	defer ib.mutex.Unlock()
+	ib.stopped = true
+	ib.cond.Broadcast()
+}
+
+// Drain rejects new pushes and waits until buffered envelopes are read or context is done.
+func (ib *Inbox) Drain(ctx context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ib.mutex.Lock()
+			ib.cond.Broadcast()
+			ib.mutex.Unlock()
+		case <-stop:
+		}
+	}()
+
+	ib.mutex.Lock()
+	defer ib.mutex.Unlock()
+	ib.stopped = true
+	ib.cond.Broadcast()
+	for (len(ib.queue) > 0 || ib.held > 0) && !ib.closed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ib.cond.Wait()
+	}
+	return nil
+}
+
+// Close stops inbox and closes read channels.
+func (ib *Inbox) Close() {
+	ib.mutex.Lock()
+	defer ib.mutex.Unlock()
+	if ib.closed {
+		return
+	}
+	ib.stopped = true
+	ib.closed = true
+	close(ib.done)
+	ib.cond.Broadcast()
+}
+
+// Moves queued envelopes to out channel.
+func (ib *Inbox) pump() {
+	defer close(ib.out)
+	for {
+		ib.mutex.Lock()
+		for len(ib.queue) == 0 && !ib.closed {
+			ib.cond.Wait()
+		}
+		if ib.closed {
+			ib.mutex.Unlock()
+			return
+		}
+		env := ib.queue[0]
+		ib.queue[0] = nil
+		ib.queue = ib.queue[1:]
+		ib.held++
+		ib.cond.Broadcast()
+		ib.mutex.Unlock()
+
+		sent := false
+		if env.Expired(time.Now()) {
+			ib.expire()
+		} else if sent = ib.send(env); !sent && ib.isClosed() {
+			return
+		}
+
+		ib.mutex.Lock()
+		if !sent || !ib.forwarding {
+			ib.release()
+		}
+		ib.mutex.Unlock()
+	}
+}
+
+// Marks held envelope as read, called with locked mutex.
+func (ib *Inbox) release() {
+	ib.held--
+	ib.cond.Broadcast()
+}
+
+// Drops held envelope if Push still waits for it, caller releases the envelope then.
+func (ib *Inbox) evicted() bool {
+	ib.mutex.Lock()
+	defer ib.mutex.Unlock()
+	if !ib.evicting {
+		return false
+	}
+	ib.evicting = false
+	ib.drop()
+	return true
+}
+
+func (ib *Inbox) isClosed() bool {
+	ib.mutex.Lock()
+	defer ib.mutex.Unlock()
+	return ib.closed
+}
+
+// Passes envelope to reader, returns false if it is expired, evicted or inbox is closed.
+func (ib *Inbox) send(env *Envelope) bool {
+	// envelope could expire while waiting for reader
+	var expire <-chan time.Time
+	if env.TTL > 0 {
+		timer := time.NewTimer(time.Until(env.ExpiresAt()))
+		defer timer.Stop()
+		expire = timer.C
+	}
+
+	for {
+		select {
+		case ib.out <- env:
+			if !ib.relay {
+				deliveredMessages.Inc()
+			}
+			return true
+		case <-ib.evict:
+			if ib.evicted() {
+				return false
+			}
+		case <-expire:
+			ib.expire()
+			return false
+		case <-ib.done:
+			return false
+		}
+	}
+}
+
+// Passes payload to Read channel, returns false if inbox is closed.
+func (ib *Inbox) forward(env *Envelope) bool {
+	for {
+		select {
+		case ib.values <- env.Payload:
+			return true
+		case <-ib.evict:
+			if ib.evicted() {
+				return true
+			}
+		case <-ib.done:
+			return false
+		}
+	}
+}
+
+// ReadEnvelope returns channel of received envelopes.
+func (ib *Inbox) ReadEnvelope() <-chan *Envelope {
+	return ib.out
+}
+
+// Read returns channel of received messages.
+func (ib *Inbox) Read() <-chan interface{} {
+	ib.valuesOnce.Do(func() {
+		ib.mutex.Lock()
+		ib.forwarding = true
+		ib.mutex.Unlock()
+		ib.values = make(chan interface{})
+		go func() {
+			defer close(ib.values)
+			for env := range ib.out {
+				if !ib.forward(env) {
+					return
+				}
+				ib.mutex.Lock()
+				ib.release()
+				ib.mutex.Unlock()
+			}
+		}()
+	})
+	return ib.values
+}
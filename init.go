@@ -1,9 +1,11 @@
 package pubsub
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -12,12 +14,21 @@ import (
 var (
 	errorNohub  = errors.New("no pubsub engine")
 	hubInstance Hub
+
+	schedulerMutex    sync.Mutex
+	schedulerInstance *Scheduler
 )
 
 // PUBLIC API
 
 // Cleanup pubsub facilities.
 func Cleanup() {
+	schedulerMutex.Lock()
+	if schedulerInstance != nil {
+		schedulerInstance.Close()
+		schedulerInstance = nil
+	}
+	schedulerMutex.Unlock()
 	if hubInstance != nil {
 		hubInstance.Close()
 		hubInstance = nil
@@ -25,16 +36,42 @@ func Cleanup() {
 	log.Info("pubsub closed")
 }
 
+// Shutdown closes pubsub facilities gracefully, see Shutdowner.
+func Shutdown(ctx context.Context) error {
+	schedulerMutex.Lock()
+	if schedulerInstance != nil {
+		schedulerInstance.Close()
+		schedulerInstance = nil
+	}
+	schedulerMutex.Unlock()
+	var err error
+	if hubInstance != nil {
+		err = ShutdownHub(ctx, hubInstance)
+		hubInstance = nil
+	}
+	log.Info("pubsub closed")
+	return err
+}
+
 // Publish message to given channels.
 func Publish(channels []string, msg interface{}) error {
 	if hubInstance == nil {
 		return errorNohub
 	}
-	log.Debug("publish to %v", channels)
+	log.Debugf("publish to %v", channels)
 	hubInstance.Publish(channels, msg)
 	return nil
 }
 
+// PublishContext sends message to given channels and waits for result.
+func PublishContext(ctx context.Context, channels []string, msg interface{}, opts ...PublishOption) (PublishResult, error) {
+	if hubInstance == nil {
+		return PublishResult{}, errorNohub
+	}
+	log.Debugf("publish to %v", channels)
+	return hubInstance.PublishContext(ctx, channels, msg, opts...)
+}
+
 // Subscribe on given channels.
 func Subscribe(channels []string) (Channel, error) {
 	if hubInstance == nil {
@@ -45,12 +82,152 @@ func Subscribe(channels []string) (Channel, error) {
 		log.Errorf("pubsub subscribe failed: %+v", err)
 		return nil, err
 	}
-	log.Debug("subscibe to %v", channels)
+	log.Debugf("subscibe to %v", channels)
 	return r, nil
 }
 
+// SubscribeContext on given channels, aborts when ctx is done.
+func SubscribeContext(ctx context.Context, channels []string, opts ...SubscribeOption) (Channel, error) {
+	if hubInstance == nil {
+		return nil, errorNohub
+	}
+	r, err := hubInstance.SubscribeContext(ctx, channels, opts...)
+	if err != nil {
+		log.Errorf("pubsub subscribe failed: %+v", err)
+		return nil, err
+	}
+	log.Debugf("subscibe to %v", channels)
+	return r, nil
+}
+
+// SubscribePattern on all channels matching given patterns.
+func SubscribePattern(patterns []string) (Channel, error) {
+	return SubscribeContext(context.Background(), patterns, Pattern())
+}
+
+// SubscribeGroup joins queue group consuming given channels,
+// each message is delivered to only one member of the group.
+func SubscribeGroup(group string, channels []string) (Channel, error) {
+	return SubscribeContext(context.Background(), channels, Group(group))
+}
+
+// Request sends message to given channel and waits for reply.
+func Request(ctx context.Context, channel string, msg interface{}) (interface{}, error) {
+	if hubInstance == nil {
+		return nil, errorNohub
+	}
+	log.Debugf("request to %s", channel)
+	rpc := &RPC{Hub: hubInstance}
+	return rpc.Request(ctx, channel, msg)
+}
+
+// Gather sends message to given channel and collects up to max replies.
+func Gather(ctx context.Context, channel string, msg interface{}, max int) ([]*Envelope, error) {
+	if hubInstance == nil {
+		return nil, errorNohub
+	}
+	log.Debugf("gather from %s", channel)
+	rpc := &RPC{Hub: hubInstance}
+	return rpc.Gather(ctx, channel, msg, max)
+}
+
+// Respond replies to requests sent to given channels.
+func Respond(channels []string, handler RequestHandler, opts ...SubscribeOption) (Channel, error) {
+	if hubInstance == nil {
+		return nil, errorNohub
+	}
+	rpc := &RPC{Hub: hubInstance}
+	return rpc.Respond(channels, handler, opts...)
+}
+
+// History returns up to n last messages of given channel.
+func History(ctx context.Context, channel string, n int) ([]*Envelope, error) {
+	if hubInstance == nil {
+		return nil, errorNohub
+	}
+	h, ok := hubInstance.(HistoryReader)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return h.History(ctx, channel, n)
+}
+
+// HistorySince returns messages of given channel published after message with given id.
+func HistorySince(ctx context.Context, channel string, id string) ([]*Envelope, error) {
+	if hubInstance == nil {
+		return nil, errorNohub
+	}
+	h, ok := hubInstance.(HistoryReader)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return h.HistorySince(ctx, channel, id)
+}
+
+// Retained returns retained message of given channel or nil.
+func Retained(ctx context.Context, channel string) (*Envelope, error) {
+	if hubInstance == nil {
+		return nil, errorNohub
+	}
+	r, ok := hubInstance.(Retainer)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return r.Retained(ctx, channel)
+}
+
+// ClearRetained removes retained message of given channel.
+func ClearRetained(ctx context.Context, channel string) error {
+	if hubInstance == nil {
+		return errorNohub
+	}
+	r, ok := hubInstance.(Retainer)
+	if !ok {
+		return ErrUnsupported
+	}
+	return r.ClearRetained(ctx, channel)
+}
+
+// PublishAt publishes message to given channels at given time,
+// returns handle to cancel publishing. Schedules are not persisted.
+func PublishAt(at time.Time, channels []string, msg interface{}, opts ...PublishOption) (string, error) {
+	s, err := getScheduler()
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("schedule publish to %v at %v", channels, at)
+	return s.PublishAt(at, channels, msg, opts...)
+}
+
+// PublishAfter publishes message to given channels after given duration,
+// returns handle to cancel publishing. Schedules are not persisted.
+func PublishAfter(d time.Duration, channels []string, msg interface{}, opts ...PublishOption) (string, error) {
+	return PublishAt(time.Now().Add(d), channels, msg, opts...)
+}
+
+// CancelScheduled cancels publishing of scheduled message with given handle.
+func CancelScheduled(id string) error {
+	s, err := getScheduler()
+	if err != nil {
+		return err
+	}
+	return s.Cancel(id)
+}
+
 // IMPLEMENTATION
 
+func getScheduler() (*Scheduler, error) {
+	if hubInstance == nil {
+		return nil, errorNohub
+	}
+	schedulerMutex.Lock()
+	defer schedulerMutex.Unlock()
+	if schedulerInstance == nil {
+		schedulerInstance, _ = NewScheduler(hubInstance, nil)
+	}
+	return schedulerInstance, nil
+}
+
 // MakeHub returns new instance of the pubsub hub.
 func MakeHub(config HubConfig) (Hub, error) {
 	if config == nil {
@@ -63,12 +240,21 @@ func MakeHub(config HubConfig) (Hub, error) {
 	}
 	d, ok := drivers[driverName]
 	if ok {
+		var codec Codec
+		if name := config.GetString("codec", ""); len(name) > 0 {
+			if codec = GetCodec(name); codec == nil {
+				return nil, fmt.Errorf("unknown codec: %s", name)
+			}
+		}
 		h, err := d.Create(config)
 		if err != nil {
 			log.Errorf("unable to connect to %s pubsub server: %+v", driverName, err)
 			return nil, err
 		}
-		log.Info("connected to %s pubsub", driverName)
+		log.Infof("connected to %s pubsub", driverName)
+		if codec != nil {
+			h = Wrap(h, UseCodec(codec))
+		}
 		return h, nil
 	}
 
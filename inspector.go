@@ -0,0 +1,34 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+)
+
+// Inspector is implemented by hubs able to describe their channels and subscriptions.
+type Inspector interface {
+	// Channels returns channels known to the hub.
+	Channels(ctx context.Context) ([]ChannelInfo, error)
+	// Subscriptions returns subscriptions opened through the hub.
+	Subscriptions(ctx context.Context) ([]SubscriptionInfo, error)
+}
+
+// ChannelInfo describes channel of the hub.
+type ChannelInfo struct {
+	Name        string  `json:"name"`
+	Subscribers int     `json:"subscribers"` // number of subscribers, -1 if unknown
+	Rate        float64 `json:"rate"`        // messages per second published through the hub recently
+}
+
+// SubscriptionInfo describes subscription opened through the hub.
+type SubscriptionInfo struct {
+	Channels []string  `json:"channels"` // subscribed channels or patterns
+	Pattern  bool      `json:"pattern,omitempty"`
+	Group    string    `json:"group,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// Age returns time passed since subscription is created.
+func (s SubscriptionInfo) Age() time.Duration {
+	return time.Since(s.Created)
+}
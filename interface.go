@@ -1,13 +1,20 @@
 package pubsub
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // Hub interface of pubsub system.
 type Hub interface {
 	// Publish sends input message to specified channels.
 	Publish(channels []string, msg interface{})
+	// PublishContext sends input message to specified channels and reports result per channel.
+	PublishContext(ctx context.Context, channels []string, msg interface{}, opts ...PublishOption) (PublishResult, error)
 	// Subscribe opens channel to listen specified channels.
 	Subscribe(channels []string) (Channel, error)
+	// SubscribeContext opens channel to listen specified channels, aborts when ctx is done.
+	SubscribeContext(ctx context.Context, channels []string, opts ...SubscribeOption) (Channel, error)
 	// Close stops the pubsub hub.
 	Close() error
 }
@@ -16,12 +23,40 @@ type Hub interface {
 type Channel interface {
 	// Read returns channel to receive events.
 	Read() <-chan interface{}
+	// ReadEnvelope returns channel to receive events with delivery metadata.
+	// Use either Read or ReadEnvelope, both share the same stream of events.
+	ReadEnvelope() <-chan *Envelope
+	// Dropped returns number of events dropped because of buffer overflow.
+	Dropped() uint64
+	// Expired returns number of events dropped because their TTL elapsed.
+	Expired() uint64
+	// Add subscribes to given channels, or patterns for pattern subscription.
+	Add(names ...string) error
+	// Remove unsubscribes from given channels, or patterns for pattern subscription.
+	Remove(names ...string) error
 	// Close stops listening underlying pubsub channels.
 	Close() error
 	// CloseNotify returns channel to receive event when this channel is closed.
 	CloseNotify() <-chan bool
 }
 
+// HistoryReader is implemented by hubs keeping channel history.
+type HistoryReader interface {
+	// History returns up to n last messages of given channel.
+	History(ctx context.Context, channel string, n int) ([]*Envelope, error)
+	// HistorySince returns messages of given channel published after message with given id,
+	// or all kept messages if id is not found.
+	HistorySince(ctx context.Context, channel string, id string) ([]*Envelope, error)
+}
+
+// Retainer is implemented by hubs keeping retained messages.
+type Retainer interface {
+	// Retained returns retained message of given channel or nil.
+	Retained(ctx context.Context, channel string) (*Envelope, error)
+	// ClearRetained removes retained message of given channel.
+	ClearRetained(ctx context.Context, channel string) error
+}
+
 // Event defines abstract event usually about HTTP update
 type Event struct {
 	ID           string      `json:"id,omitempty"`     // event id
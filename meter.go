@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of seconds Meter averages rate over.
+const meterWindow = 10
+
+// Meter measures rate of events per second over last 10 seconds, used by drivers.
+type Meter struct {
+	mutex   sync.Mutex
+	buckets [meterWindow]uint64
+	last    int64
+	count   uint64
+}
+
+// Mark registers event.
+func (m *Meter) Mark() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now().Unix()
+	m.advance(now)
+	m.buckets[now%meterWindow]++
+	m.count++
+}
+
+// Rate returns average number of events per second.
+func (m *Meter) Rate() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.advance(time.Now().Unix())
+	var sum uint64
+	for _, n := range m.buckets {
+		sum += n
+	}
+	return float64(sum) / meterWindow
+}
+
+// Count returns total number of events.
+func (m *Meter) Count() uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.count
+}
+
+// Resets buckets of seconds passed since last event.
+func (m *Meter) advance(now int64) {
+	if now-m.last >= meterWindow {
+		m.buckets = [meterWindow]uint64{}
+	} else {
+		for s := m.last + 1; s <= now; s++ {
+			m.buckets[s%meterWindow] = 0
+		}
+	}
+	if now > m.last {
+		m.last = now
+	}
+}
+
+// Meters measures rates of events per channel, zero value is ready to use.
+type Meters struct {
+	mutex  sync.Mutex
+	meters map[string]*Meter
+}
+
+// Mark registers event of given channel.
+func (ms *Meters) Mark(channel string) {
+	ms.mutex.Lock()
+	m, ok := ms.meters[channel]
+	if !ok {
+		if ms.meters == nil {
+			ms.meters = make(map[string]*Meter)
+		}
+		m = &Meter{}
+		ms.meters[channel] = m
+	}
+	ms.mutex.Unlock()
+	m.Mark()
+}
+
+// Rate returns average number of events per second of given channel.
+func (ms *Meters) Rate(channel string) float64 {
+	ms.mutex.Lock()
+	m, ok := ms.meters[channel]
+	ms.mutex.Unlock()
+	if !ok {
+		return 0
+	}
+	return m.Rate()
+}
+
+// Active returns channels having recent events, meters of idle channels are removed.
+func (ms *Meters) Active() []string {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	var names []string
+	for name, m := range ms.meters {
+		if m.Rate() == 0 {
+			delete(ms.meters, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
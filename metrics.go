@@ -0,0 +1,80 @@
+package pubsub
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	publishedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pubsub",
+		Name:      "published_messages_total",
+		Help:      "Number of messages published to channels.",
+	}, []string{"driver"})
+
+	publishErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pubsub",
+		Name:      "publish_errors_total",
+		Help:      "Number of failed publishes to channels.",
+	}, []string{"driver"})
+
+	deliveredMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "pubsub",
+		Name:      "delivered_messages_total",
+		Help:      "Number of messages delivered to subscribers.",
+	})
+
+	droppedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pubsub",
+		Name:      "dropped_messages_total",
+		Help:      "Number of messages dropped before delivery to subscribers.",
+	}, []string{"reason"})
+
+	codecErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pubsub",
+		Name:      "codec_errors_total",
+		Help:      "Number of messages failed to marshal or unmarshal.",
+	}, []string{"op"})
+
+	activeSubscriptions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pubsub",
+		Name:      "subscriptions",
+		Help:      "Number of open subscriptions.",
+	}, []string{"driver"})
+)
+
+// RegisterMetrics registers metrics of hubs and drivers with given registry,
+// e.g. prometheus.DefaultRegisterer.
+func RegisterMetrics(r prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		publishedMessages,
+		publishErrors,
+		deliveredMessages,
+		droppedMessages,
+		codecErrors,
+		activeSubscriptions,
+	} {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ObservePublish counts messages published by given driver, used by drivers.
+func ObservePublish(driver string, result PublishResult) {
+	for _, c := range result.Channels {
+		if c.Err != nil {
+			publishErrors.WithLabelValues(driver).Inc()
+			continue
+		}
+		publishedMessages.WithLabelValues(driver).Inc()
+	}
+}
+
+// ObserveSubscription counts subscription opened by given driver, used by drivers.
+// Returned function must be called once subscription is closed.
+func ObserveSubscription(driver string) func() {
+	g := activeSubscriptions.WithLabelValues(driver)
+	g.Inc()
+	return g.Dec
+}
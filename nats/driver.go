@@ -13,12 +13,22 @@ func init() {
 type driver struct{}
 
 func (d *driver) Create(config pubsub.HubConfig) (pubsub.Hub, error) {
-
-	url, ok := config["url"].(string)
-	if ok {
-		return Open(url)
+	compress, err := pubsub.CompressionConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := pubsub.SignerConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	url, _ := config["url"].(string)
+	h, err := Open(url)
+	if err != nil {
+		return nil, err
 	}
-	return Open("")
+	h.(*hub).compress = compress
+	h.(*hub).signer = signer
+	return h, nil
 }
 
 // Open creates pubsub hub connected to nats server.
@@ -1,59 +1,167 @@
 package nats
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/gocontrib/pubsub"
 	nats "github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
 )
 
 // pubsub.Hub impl
 
 type hub struct {
 	sync.Mutex
-	conn *nats.Conn
-	subs map[*sub]struct{}
+	conn     *nats.Conn
+	subs     map[*sub]struct{}
+	meters   pubsub.Meters
+	compress pubsub.Compression
+	signer   *pubsub.Signer
+	closing  bool           // set by Shutdown, rejects publishes and subscriptions
+	inflight sync.WaitGroup // pending publishes, waited by Shutdown
 }
 
 func (h *hub) Publish(channels []string, msg interface{}) {
 	if len(channels) == 0 {
 		return
 	}
+	// registered before returning, so Shutdown waits for it
+	if !h.beginPublish() {
+		log.Errorf("nats publish failed: %+v", pubsub.ErrClosed)
+		return
+	}
 	go func() {
-		var data, err = pubsub.Marshal(msg)
+		defer h.inflight.Done()
+		_, err := h.publishContext(context.Background(), channels, msg)
 		if err != nil {
-			return
-		}
-		for _, cn := range channels {
-			h.conn.Publish(cn, data)
+			log.Errorf("nats publish failed: %+v", err)
 		}
 	}()
 }
 
-func (h *hub) Subscribe(channels []string) (pubsub.Channel, error) {
+func (h *hub) PublishContext(ctx context.Context, channels []string, msg interface{}, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+	if !h.beginPublish() {
+		return pubsub.PublishResult{}, pubsub.ErrClosed
+	}
+	defer h.inflight.Done()
+	return h.publishContext(ctx, channels, msg, opts...)
+}
 
-	s := &sub{
-		hub:    h,
-		send:   make(chan interface{}),
-		closed: make(chan bool),
+// Publishes message registered as pending publish.
+func (h *hub) publishContext(ctx context.Context, channels []string, msg interface{}, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+	var result pubsub.PublishResult
+	if len(channels) == 0 {
+		return result, nil
 	}
+	if pubsub.MakePublishOptions(opts...).Retain {
+		// nats keeps no messages
+		return result, pubsub.ErrUnsupported
+	}
+
+	env := pubsub.NewEnvelope(msg, opts...)
+	pubsub.InjectTrace(ctx, env)
+	defer func() { pubsub.ObservePublish("nats", result) }()
 
-	for _, subject := range channels {
-		sub, err := h.conn.Subscribe(subject, s.Handler)
+	for _, cn := range channels {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		data, err := h.compress.EncodeEnvelope(env.ForChannel(cn))
+		if err == nil {
+			data, err = h.signer.Sign(data)
+		}
 		if err != nil {
-			s.Close()
-			return nil, err
+			return result, err
 		}
-		s.subs = append(s.subs, sub)
+		// nats does not report number of receivers
+		if replyTo := env.Header(pubsub.HeaderReplyTo); len(replyTo) > 0 {
+			err = h.conn.PublishRequest(cn, replyTo, data)
+		} else {
+			err = h.conn.Publish(cn, data)
+		}
+		if err == nil {
+			h.meters.Mark(cn)
+		}
+		result.Add(cn, -1, err)
+	}
+
+	if err := h.flush(ctx); err != nil {
+		return result, err
+	}
+
+	return result, result.Err()
+}
+
+// NewReplyChannel returns unique nats inbox subject.
+func (h *hub) NewReplyChannel() string {
+	return nats.NewInbox()
+}
+
+func (h *hub) Subscribe(channels []string) (pubsub.Channel, error) {
+	return h.SubscribeContext(context.Background(), channels)
+}
+
+func (h *hub) SubscribeContext(ctx context.Context, channels []string, opts ...pubsub.SubscribeOption) (pubsub.Channel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if h.isClosing() {
+		return nil, pubsub.ErrClosed
+	}
+
+	options := pubsub.MakeSubscribeOptions(opts...)
+	if err := options.Err(); err != nil {
+		return nil, err
+	}
+	if options.HasReplay() {
+		// core nats keeps no history
+		return nil, pubsub.ErrUnsupported
+	}
+	if options.Member != nil {
+		// nats does not share subscriptions state between clients
+		return nil, pubsub.ErrUnsupported
+	}
+
+	s := &sub{
+		hub:      h,
+		pattern:  options.Pattern,
+		group:    options.Group,
+		subs:     make(map[string]*nats.Subscription),
+		created:  time.Now(),
+		verifier: h.signer.NewVerifier(),
+		closed:   make(chan bool),
+		observed: pubsub.ObserveSubscription("nats"),
+	}
+	s.inbox = pubsub.NewInbox(options, func() { s.Close() })
+
+	if err := s.add(ctx, channels); err != nil {
+		s.Close()
+		return nil, err
 	}
 
 	h.Lock()
-	defer h.Unlock()
 	h.subs[s] = struct{}{}
+	h.Unlock()
 
 	return s, nil
 }
 
+// flushTimeout limits flush when context has no deadline.
+const flushTimeout = 60 * time.Second
+
+// flush waits until server processes buffered commands.
+func (h *hub) flush(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flushTimeout)
+		defer cancel()
+	}
+	return h.conn.FlushWithContext(ctx)
+}
+
 func (h *hub) Close() error {
 	h.Lock()
 	defer h.Unlock()
@@ -64,6 +172,60 @@ func (h *hub) Close() error {
 	return nil
 }
 
+// Shutdown stops accepting publishes and subscriptions, flushes pending publishes
+// and drains all subscriptions before closing connection.
+func (h *hub) Shutdown(ctx context.Context) error {
+	h.Lock()
+	h.closing = true
+	subs := make([]*sub, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.Unlock()
+
+	err := pubsub.WaitContext(ctx, &h.inflight)
+	if err == nil {
+		err = h.flush(ctx)
+	}
+	if err == nil {
+		var wg sync.WaitGroup
+		errs := make(chan error, len(subs))
+		for _, s := range subs {
+			wg.Add(1)
+			go func(s *sub) {
+				defer wg.Done()
+				errs <- s.drain(ctx)
+			}(s)
+		}
+		wg.Wait()
+		close(errs)
+		for e := range errs {
+			if e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	h.Close()
+	return err
+}
+
+// Registers pending publish unless hub is shutting down.
+func (h *hub) beginPublish() bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.closing {
+		return false
+	}
+	h.inflight.Add(1)
+	return true
+}
+
+func (h *hub) isClosing() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.closing
+}
+
 func (h *hub) remove(s *sub) bool {
 	h.Lock()
 	defer h.Unlock()
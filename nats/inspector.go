@@ -0,0 +1,63 @@
+package nats
+
+import (
+	"context"
+	"sort"
+
+	"github.com/gocontrib/pubsub"
+)
+
+// Channels returns subjects of local subscriptions and subjects recently published through this hub.
+// Nats server does not expose remote interest to clients, so only local subscribers are counted.
+func (h *hub) Channels(ctx context.Context) ([]pubsub.ChannelInfo, error) {
+	subs, err := h.Subscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, s := range subs {
+		for _, subject := range s.Channels {
+			counts[subject]++
+		}
+	}
+	for _, subject := range h.meters.Active() {
+		if _, ok := counts[subject]; !ok {
+			counts[subject] = 0
+		}
+	}
+
+	list := make([]pubsub.ChannelInfo, 0, len(counts))
+	for subject, n := range counts {
+		list = append(list, pubsub.ChannelInfo{
+			Name:        subject,
+			Subscribers: n,
+			Rate:        h.meters.Rate(subject),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// Subscriptions returns subscriptions opened through this hub.
+func (h *hub) Subscriptions(ctx context.Context) ([]pubsub.SubscriptionInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h.Lock()
+	subs := make([]*sub, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.Unlock()
+
+	list := make([]pubsub.SubscriptionInfo, 0, len(subs))
+	for _, s := range subs {
+		list = append(list, s.info())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list, nil
+}
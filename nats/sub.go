@@ -1,57 +1,208 @@
 package nats
 
 import (
+	"context"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/gocontrib/pubsub"
 	nats "github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
 )
 
 // Subscription channel.
 type sub struct {
 	sync.Mutex
-	hub    *hub
-	subs   []*nats.Subscription
-	send   chan interface{}
-	closed chan bool
+	hub      *hub
+	pattern  bool
+	group    string
+	subs     map[string]*nats.Subscription
+	created  time.Time
+	observed func()
+	verifier *pubsub.Verifier // checks signatures of received messages
+	inbox    *pubsub.Inbox
+	closed   chan bool
 }
 
 func (s *sub) Read() <-chan interface{} {
-	return s.send
+	return s.inbox.Read()
+}
+
+func (s *sub) ReadEnvelope() <-chan *pubsub.Envelope {
+	return s.inbox.ReadEnvelope()
+}
+
+func (s *sub) Add(subjects ...string) error {
+	return s.add(context.Background(), subjects)
+}
+
+func (s *sub) add(ctx context.Context, subjects []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.hub == nil {
+		return pubsub.ErrClosed
+	}
+
+	for _, subject := range subjects {
+		if s.pattern {
+			// nats subjects natively support the same wildcards
+			if err := pubsub.ValidatePattern(subject); err != nil {
+				return err
+			}
+		} else if pubsub.IsPattern(subject) {
+			// other drivers treat wildcards in channel names literally
+			return fmt.Errorf("nats: wildcard in channel name %q, subscribe with pattern option", subject)
+		}
+		if _, ok := s.subs[subject]; ok {
+			continue
+		}
+		t, err := s.subscribe(subject)
+		if err != nil {
+			return err
+		}
+		s.subs[subject] = t
+	}
+	// make sure server has processed subscriptions
+	return s.hub.flush(ctx)
+}
+
+func (s *sub) subscribe(subject string) (*nats.Subscription, error) {
+	if len(s.group) > 0 {
+		return s.hub.conn.QueueSubscribe(subject, s.group, s.Handler)
+	}
+	return s.hub.conn.Subscribe(subject, s.Handler)
+}
+
+func (s *sub) Remove(subjects ...string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.hub == nil {
+		return pubsub.ErrClosed
+	}
+
+	for _, subject := range subjects {
+		t, ok := s.subs[subject]
+		if !ok {
+			continue
+		}
+		delete(s.subs, subject)
+		if err := t.Unsubscribe(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *sub) Close() error {
 	go func() {
 		s.Lock()
-		defer s.Unlock()
-
 		if s.hub == nil {
+			s.Unlock()
 			return
 		}
 
+		s.inbox.Stop()
 		s.hub.remove(s)
 		s.hub = nil
+		s.observed()
 
 		for _, t := range s.subs {
 			t.Unsubscribe()
 		}
+		s.Unlock()
 
 		s.closed <- true
-		close(s.send)
+		s.inbox.Close()
 	}()
 	return nil
 }
 
+// drainInterval is period of polling drained nats subscriptions.
+const drainInterval = 10 * time.Millisecond
+
+// Drains nats subscriptions, waits until received messages are read and closes subscription.
+func (s *sub) drain(ctx context.Context) error {
+	s.Lock()
+	if s.hub == nil {
+		s.Unlock()
+		return nil
+	}
+	var subs []*nats.Subscription
+	for _, t := range s.subs {
+		if err := t.Drain(); err == nil {
+			subs = append(subs, t)
+		}
+	}
+	s.Unlock()
+
+	defer s.Close()
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for _, t := range subs {
+		for t.IsValid() {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return s.inbox.Drain(ctx)
+}
+
+func (s *sub) Dropped() uint64 {
+	return s.inbox.Dropped()
+}
+
+func (s *sub) Expired() uint64 {
+	return s.inbox.Expired()
+}
+
 func (s *sub) CloseNotify() <-chan bool {
 	return s.closed
 }
 
 func (s *sub) Handler(msg *nats.Msg) {
-	go func() {
-		v, err := pubsub.Unmarshal(msg.Data)
-		if err != nil {
-			return
-		}
-		s.send <- v
-	}()
+	data, err := s.verifier.Verify(msg.Data)
+	if err != nil {
+		log.Errorf("nats: message of %s rejected: %v", msg.Subject, err)
+		return
+	}
+	env, err := pubsub.DecodeEnvelope(data)
+	if err != nil {
+		log.Errorf("nats: cannot decode message of %s: %v", msg.Subject, err)
+		return
+	}
+	if err := s.verifier.VerifyChannel(env, msg.Subject); err != nil {
+		log.Errorf("nats: message of %s signed for %s rejected: %v", msg.Subject, env.Channel, err)
+		return
+	}
+	if len(env.Channel) == 0 {
+		env.Channel = msg.Subject
+	}
+	if len(msg.Reply) > 0 && len(env.Header(pubsub.HeaderReplyTo)) == 0 {
+		// native nats request
+		env.SetHeader(pubsub.HeaderReplyTo, msg.Reply)
+	}
+	s.inbox.Push(env)
+}
+
+// Returns subscription info.
+func (s *sub) info() pubsub.SubscriptionInfo {
+	s.Lock()
+	defer s.Unlock()
+	info := pubsub.SubscriptionInfo{
+		Pattern: s.pattern,
+		Group:   s.group,
+		Created: s.created,
+	}
+	for subject := range s.subs {
+		info.Channels = append(info.Channels, subject)
+	}
+	sort.Strings(info.Channels)
+	return info
 }
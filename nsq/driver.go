@@ -2,6 +2,7 @@ package nsq
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gocontrib/pubsub"
 	nsq "github.com/nsqio/go-nsq"
@@ -18,13 +19,20 @@ func (c nsqConfig) nodeAddr() string {
 }
 
 func (c nsqConfig) lookupdAddr() string {
-	return c.config.GetString("nsqlookupd", "127.0.0.1:4160")
+	// http address
+	return c.config.GetString("nsqlookupd", "127.0.0.1:4161")
 }
 
 func (c nsqConfig) maxInFlight() int {
 	return c.config.GetInt("maxinflight", 1000)
 }
 
+// nsqd buffers messages sent to consumer up to this timeout, in milliseconds,
+// 25 is the least value accepted by nsqd by default
+func (c nsqConfig) outputBufferTimeout() time.Duration {
+	return time.Duration(c.config.GetInt("outputbuffertimeout", 25)) * time.Millisecond
+}
+
 func init() {
 	pubsub.RegisterDriver(&driver{}, "nsq", "nsqio")
 }
@@ -35,6 +43,14 @@ func (d *driver) Create(config pubsub.HubConfig) (pubsub.Hub, error) {
 	log.Info("connecting to nsq pubsub")
 
 	cfg := nsqConfig{config}
+	compress, err := pubsub.CompressionConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := pubsub.SignerConfig(config)
+	if err != nil {
+		return nil, err
+	}
 
 	addr := cfg.nodeAddr()
 	producer, err := nsq.NewProducer(addr, makeConfig(cfg))
@@ -42,12 +58,19 @@ func (d *driver) Create(config pubsub.HubConfig) (pubsub.Hub, error) {
 		return nil, err
 	}
 
-	return &hub{cfg, producer}, nil
+	return &hub{
+		config:   cfg,
+		producer: producer,
+		compress: compress,
+		signer:   signer,
+		subs:     make(map[*sub]struct{}),
+	}, nil
 }
 
 func makeConfig(config nsqConfig) *nsq.Config {
 	cfg := nsq.NewConfig()
 	cfg.UserAgent = fmt.Sprintf("nsq_pubsub/%s go-nsq/%s", "0.0.1", nsq.VERSION)
 	cfg.MaxInFlight = config.maxInFlight()
+	cfg.OutputBufferTimeout = config.outputBufferTimeout()
 	return cfg
 }
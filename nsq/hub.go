@@ -1,7 +1,10 @@
 package nsq
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/gocontrib/pubsub"
 	nsq "github.com/nsqio/go-nsq"
@@ -10,69 +13,229 @@ import (
 
 // NSQ pubsub hub
 type hub struct {
+	sync.Mutex
 	config   nsqConfig
 	producer *nsq.Producer
+	compress pubsub.Compression
+	signer   *pubsub.Signer
+	subs     map[*sub]struct{}
+	closing  bool           // set by Shutdown, rejects publishes and subscriptions
+	inflight sync.WaitGroup // pending publishes, waited by Shutdown
 }
 
 func (h *hub) Publish(channels []string, msg interface{}) {
+	if len(channels) == 0 {
+		return
+	}
+	// registered before returning, so Shutdown waits for it
+	if !h.beginPublish() {
+		log.Errorf("nsq publish failed: %+v", pubsub.ErrClosed)
+		return
+	}
 	go func() {
-		var body, err = pubsub.Marshal(msg)
+		defer h.inflight.Done()
+		_, err := h.publishContext(context.Background(), channels, msg)
 		if err != nil {
-			return
-		}
-		for _, name := range channels {
-			h.producer.Publish(escapeChannelName(name), body)
+			log.Errorf("nsq publish failed: %+v", err)
 		}
 	}()
 }
 
-func (h *hub) Subscribe(channels []string) (pubsub.Channel, error) {
-	s := &sub{
-		closed: make(chan bool),
-		send:   make(chan interface{}),
+func (h *hub) PublishContext(ctx context.Context, channels []string, msg interface{}, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+	if !h.beginPublish() {
+		return pubsub.PublishResult{}, pubsub.ErrClosed
+	}
+	defer h.inflight.Done()
+	return h.publishContext(ctx, channels, msg, opts...)
+}
+
+// Publishes message registered as pending publish.
+func (h *hub) publishContext(ctx context.Context, channels []string, msg interface{}, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+	var result pubsub.PublishResult
+	if len(channels) == 0 {
+		return result, nil
 	}
+	if pubsub.MakePublishOptions(opts...).Retain {
+		// nsq keeps no messages
+		return result, pubsub.ErrUnsupported
+	}
+
+	var env = pubsub.NewEnvelope(msg, opts...)
+	pubsub.InjectTrace(ctx, env)
+	defer func() { pubsub.ObservePublish("nsq", result) }()
+
 	for _, name := range channels {
-		var c, err = h.makeConsumer(escapeChannelName(name), s)
+		body, err := h.compress.EncodeEnvelope(env.ForChannel(name))
+		if err == nil {
+			body, err = h.signer.Sign(body)
+		}
 		if err != nil {
-			return nil, err
+			return result, err
 		}
-		s.consumers = append(s.consumers, c)
+		done := make(chan *nsq.ProducerTransaction, 1)
+		err = h.producer.PublishAsync(escapeChannelName(name), body, done)
+		if err == nil {
+			select {
+			case t := <-done:
+				err = t.Error
+			case <-ctx.Done():
+				return result, ctx.Err()
+			}
+		}
+		// nsq does not report number of receivers
+		result.Add(name, -1, err)
 	}
-	return s, nil
+
+	return result, result.Err()
 }
 
-func (h *hub) makeConsumer(topic string, handler nsq.Handler) (*nsq.Consumer, error) {
-	// TODO fix channel name
-	var c, err = nsq.NewConsumer(topic, "hub", makeConfig(h.config))
-	if err != nil {
+func (h *hub) Subscribe(channels []string) (pubsub.Channel, error) {
+	return h.SubscribeContext(context.Background(), channels)
+}
+
+func (h *hub) SubscribeContext(ctx context.Context, channels []string, opts ...pubsub.SubscribeOption) (pubsub.Channel, error) {
+	h.Lock()
+	closing := h.closing
+	h.Unlock()
+	if closing {
+		return nil, pubsub.ErrClosed
+	}
+
+	options := pubsub.MakeSubscribeOptions(opts...)
+	if err := options.Err(); err != nil {
 		return nil, err
 	}
+	if options.Pattern || options.HasReplay() || options.Member != nil {
+		return nil, pubsub.ErrUnsupported
+	}
 
-	c.AddHandler(handler)
+	var channel = options.Group
+	if len(channel) == 0 {
+		// unique channel to receive copy of every message
+		channel = pubsub.NewID() + "#ephemeral"
+	} else if !nsq.IsValidChannelName(channel) {
+		return nil, fmt.Errorf("invalid nsq channel name: %s", channel)
+	}
 
-	lookupdAddr := h.config.lookupdAddr()
-	err = c.ConnectToNSQLookupd(lookupdAddr)
+	s := &sub{
+		hub:       h,
+		channel:   channel,
+		consumers: make(map[string]*nsq.Consumer),
+		verifier:  h.signer.NewVerifier(),
+		closed:    make(chan bool),
+		observed:  pubsub.ObserveSubscription("nsq"),
+	}
+	s.inbox = pubsub.NewInbox(options, func() { s.Close() })
+
+	if err := ctx.Err(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.Add(channels...); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	h.Lock()
+	h.subs[s] = struct{}{}
+	h.Unlock()
+
+	return s, nil
+}
+
+func (h *hub) makeConsumer(topic, channel string, handler nsq.Handler) (*nsq.Consumer, error) {
+	var c, err = nsq.NewConsumer(topic, channel, makeConfig(h.config))
 	if err != nil {
-		log.Errorf("cannot connect to nsqlookupd at %s: %v", lookupdAddr, err)
-	} else {
-		return c, nil
+		return nil, err
 	}
 
+	c.AddHandler(handler)
+
+	// direct connection subscribes before returning,
+	// lookupd does not know new topics until next poll
 	nodeAddr := h.config.nodeAddr()
 	err = c.ConnectToNSQD(nodeAddr)
 	if err != nil {
 		log.Errorf("cannot connect to nsqd at %s: %v", nodeAddr, err)
+		c.Stop()
 		return nil, err
 	}
 
+	// lookupd discovers other nodes
+	lookupdAddr := h.config.lookupdAddr()
+	err = c.ConnectToNSQLookupd(lookupdAddr)
+	if err != nil {
+		log.Errorf("cannot connect to nsqlookupd at %s: %v", lookupdAddr, err)
+	}
+
 	return c, nil
 }
 
 func (h *hub) Close() error {
+	h.Lock()
+	subs := make([]*sub, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.Unlock()
+	for _, s := range subs {
+		s.Close()
+	}
 	h.producer.Stop()
 	return nil
 }
 
+// Shutdown stops accepting publishes and subscriptions, waits for pending publishes,
+// stops consumers and delivers already received messages before closing producer.
+func (h *hub) Shutdown(ctx context.Context) error {
+	h.Lock()
+	h.closing = true
+	subs := make([]*sub, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.Unlock()
+
+	err := pubsub.WaitContext(ctx, &h.inflight)
+	if err == nil {
+		var wg sync.WaitGroup
+		errs := make(chan error, len(subs))
+		for _, s := range subs {
+			wg.Add(1)
+			go func(s *sub) {
+				defer wg.Done()
+				errs <- s.drain(ctx)
+			}(s)
+		}
+		wg.Wait()
+		close(errs)
+		for e := range errs {
+			if e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	h.Close()
+	return err
+}
+
+// Registers pending publish unless hub is shutting down.
+func (h *hub) beginPublish() bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.closing {
+		return false
+	}
+	h.inflight.Add(1)
+	return true
+}
+
+func (h *hub) remove(s *sub) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.subs, s)
+}
+
 func escapeChannelName(name string) string {
 	return strings.Replace(name, "/", "-", -1)
 }
@@ -1,44 +1,150 @@
 package nsq
 
 import (
+	"context"
+	"sync"
+
 	"github.com/gocontrib/pubsub"
 	"github.com/nsqio/go-nsq"
+	log "github.com/sirupsen/logrus"
 )
 
 // Subscription channel.
 type sub struct {
-	consumers []*nsq.Consumer
+	sync.Mutex
+	once      sync.Once
+	hub       *hub
+	channel   string
+	consumers map[string]*nsq.Consumer
 	closed    chan bool
-	send      chan interface{}
+	observed  func()
+	verifier  *pubsub.Verifier // checks signatures of received messages
+	inbox     *pubsub.Inbox
 }
 
 func (s *sub) Read() <-chan interface{} {
-	return s.send
+	return s.inbox.Read()
+}
+
+func (s *sub) ReadEnvelope() <-chan *pubsub.Envelope {
+	return s.inbox.ReadEnvelope()
+}
+
+func (s *sub) Add(names ...string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.consumers == nil {
+		return pubsub.ErrClosed
+	}
+
+	for _, name := range names {
+		if _, ok := s.consumers[name]; ok {
+			continue
+		}
+		var c, err = s.hub.makeConsumer(escapeChannelName(name), s.channel, s.handler(name))
+		if err != nil {
+			return err
+		}
+		s.consumers[name] = c
+	}
+	return nil
+}
+
+func (s *sub) Remove(names ...string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.consumers == nil {
+		return pubsub.ErrClosed
+	}
+
+	for _, name := range names {
+		if c, ok := s.consumers[name]; ok {
+			delete(s.consumers, name)
+			go c.Stop()
+		}
+	}
+	return nil
 }
 
 func (s *sub) Close() error {
-	go func() {
-		s.closed <- true
-	}()
-	go func() {
-		for _, c := range s.consumers {
-			c.Stop()
-		}
-	}()
+	s.once.Do(func() {
+		s.Lock()
+		consumers := s.consumers
+		s.consumers = nil
+		s.Unlock()
+
+		s.hub.remove(s)
+		s.inbox.Stop()
+		s.observed()
+		go func() {
+			s.closed <- true
+			s.inbox.Close()
+		}()
+		go func() {
+			for _, c := range consumers {
+				c.Stop()
+			}
+		}()
+	})
 	return nil
 }
 
+// Stops consumers, waits until received messages are read and closes subscription.
+func (s *sub) drain(ctx context.Context) error {
+	s.Lock()
+	var consumers []*nsq.Consumer
+	for _, c := range s.consumers {
+		consumers = append(consumers, c)
+		c.Stop()
+	}
+	s.Unlock()
+
+	defer s.Close()
+	for _, c := range consumers {
+		select {
+		case <-c.StopChan:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.inbox.Drain(ctx)
+}
+
+func (s *sub) Dropped() uint64 {
+	return s.inbox.Dropped()
+}
+
+func (s *sub) Expired() uint64 {
+	return s.inbox.Expired()
+}
+
 func (s *sub) CloseNotify() <-chan bool {
 	return s.closed
 }
 
-func (s *sub) HandleMessage(msg *nsq.Message) error {
-	go func() {
-		v, err := pubsub.Unmarshal(msg.Body)
+// Returns handler of messages received from given channel.
+func (s *sub) handler(channel string) nsq.HandlerFunc {
+	return func(msg *nsq.Message) error {
+		data, err := s.verifier.Verify(msg.Body)
 		if err != nil {
-			return
+			log.Errorf("nsq: message of %s rejected: %v", channel, err)
+			return nil
 		}
-		s.send <- v
-	}()
-	return nil
+		env, err := pubsub.DecodeEnvelope(data)
+		if err != nil {
+			log.Errorf("nsq: cannot decode message of %s: %v", channel, err)
+			return nil
+		}
+		if err := s.verifier.VerifyChannel(env, channel); err != nil {
+			log.Errorf("nsq: message of %s signed for %s rejected: %v", channel, env.Channel, err)
+			return nil
+		}
+		if len(env.Channel) == 0 {
+			env.Channel = channel
+		}
+		s.inbox.Push(env)
+		return nil
+	}
 }
@@ -0,0 +1,189 @@
+package pubsub
+
+import "time"
+
+// SubscribeOptions defines optional settings of subscription.
+type SubscribeOptions struct {
+	// Pattern treats given channel names as patterns, see MatchPattern.
+	Pattern bool
+	// BufferSize is capacity of subscriber buffer.
+	BufferSize int
+	// Overflow defines what to do when buffer is full.
+	Overflow OverflowPolicy
+	// Group makes subscription member of given queue group,
+	// each message is delivered to only one member of the group.
+	Group string
+	// Replay delivers up to given number of last messages from channel history
+	// before live messages.
+	Replay int
+	// ReplaySince delivers messages from channel history published after
+	// message with given id before live messages.
+	ReplaySince string
+	// Filter drops messages not matching expression before delivery.
+	Filter *FilterExpr
+	// Member is identity of subscriber tracked by presence, see PresenceReader.
+	Member *Member
+
+	err error
+}
+
+// Err returns error of invalid option, drivers fail subscription with it.
+func (o SubscribeOptions) Err() error {
+	return o.err
+}
+
+// HasReplay reports whether history replay is requested.
+func (o SubscribeOptions) HasReplay() bool {
+	return o.Replay > 0 || len(o.ReplaySince) > 0
+}
+
+// SubscribeOption configures subscription.
+type SubscribeOption func(*SubscribeOptions)
+
+// MakeSubscribeOptions applies given options, used by drivers.
+func MakeSubscribeOptions(opts ...SubscribeOption) SubscribeOptions {
+	var o = SubscribeOptions{
+		BufferSize: DefaultBufferSize,
+		Overflow:   OverflowBlock,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Pattern option subscribes to all channels matching given patterns.
+func Pattern() SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Pattern = true
+	}
+}
+
+// Buffer option sets capacity of subscriber buffer and overflow policy,
+// capacity includes events passed to Read or ReadEnvelope channel but not received yet.
+func Buffer(size int, policy OverflowPolicy) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.BufferSize = size
+		o.Overflow = policy
+	}
+}
+
+// Group option makes subscription member of given queue group
+// receiving messages in competition with other members.
+func Group(name string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Group = name
+	}
+}
+
+// Replay option delivers up to n last messages kept in channel history
+// before switching to live messages. Not supported by queue groups.
+func Replay(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Replay = n
+	}
+}
+
+// ReplaySince option delivers messages kept in channel history published after
+// message with given id before switching to live messages.
+// All kept messages are replayed if id is not found in history.
+func ReplaySince(id string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.ReplaySince = id
+	}
+}
+
+// Filter option delivers only messages which payload matches given expression,
+// see FilterExpr. Invalid expression fails subscription.
+func Filter(expr string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		f, err := ParseFilter(expr)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.Filter = f
+	}
+}
+
+// Identity option makes subscriber member of subscribed channels visible to presence,
+// see PresenceReader. Pattern subscriptions are not tracked.
+func Identity(id string, meta map[string]interface{}) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Member = &Member{ID: id, Meta: meta}
+	}
+}
+
+// PublishOptions defines optional settings of published message.
+type PublishOptions struct {
+	// Retain keeps message as the last value of channel delivered first to new subscribers.
+	Retain bool
+	// TTL limits time to live of message, expired messages are dropped.
+	TTL time.Duration
+	// ContentType names codec encoding message, see HeaderContentType.
+	ContentType string
+	// MessageType names registered type of message, see HeaderMessageType.
+	MessageType string
+}
+
+// PublishOption configures published message.
+type PublishOption func(*PublishOptions)
+
+// MakePublishOptions applies given options, used by drivers.
+func MakePublishOptions(opts ...PublishOption) PublishOptions {
+	var o PublishOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Retain option keeps message as the last value of channel,
+// it is delivered first to every new subscriber of the channel.
+func Retain() PublishOption {
+	return func(o *PublishOptions) {
+		o.Retain = true
+	}
+}
+
+// TTL option drops message if it is not delivered within given duration,
+// see Envelope.TTL.
+func TTL(d time.Duration) PublishOption {
+	return func(o *PublishOptions) {
+		o.TTL = d
+	}
+}
+
+// ContentType option encodes message with codec registered with given content type or alias.
+func ContentType(name string) PublishOption {
+	return func(o *PublishOptions) {
+		o.ContentType = name
+	}
+}
+
+// MessageType option marks message with given type name, so receivers decode it into registered type.
+// Messages of registered types are marked automatically.
+func MessageType(name string) PublishOption {
+	return func(o *PublishOptions) {
+		o.MessageType = name
+	}
+}
+
+// HubOptions defines optional settings of in-memory hub.
+type HubOptions struct {
+	// HistorySize is number of messages kept per channel, history is disabled if zero.
+	HistorySize int
+	// HistoryMaxAge limits age of kept messages, unlimited if zero.
+	HistoryMaxAge time.Duration
+}
+
+// HubOption configures in-memory hub.
+type HubOption func(*HubOptions)
+
+// KeepHistory option keeps up to size last messages per channel not older than maxAge.
+func KeepHistory(size int, maxAge time.Duration) HubOption {
+	return func(o *HubOptions) {
+		o.HistorySize = size
+		o.HistoryMaxAge = maxAge
+	}
+}
@@ -0,0 +1,60 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pattern syntax is shared by all drivers.
+//
+// Channel names are split into tokens by '.'. In patterns token '*' matches
+// exactly one token, e.g. "orders.*" matches "orders.new". Token '>' matches
+// one or more tokens and allowed only as last token, e.g. "orders.>" matches
+// "orders.new" and "orders.new.paid". Other tokens match literally.
+const (
+	tokenSeparator = "."
+	tokenAny       = "*"
+	tokenRest      = ">"
+)
+
+// IsPattern reports whether name contains wildcard tokens.
+func IsPattern(name string) bool {
+	for _, t := range strings.Split(name, tokenSeparator) {
+		if t == tokenAny || t == tokenRest {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePattern checks syntax of given pattern.
+func ValidatePattern(pattern string) error {
+	tokens := strings.Split(pattern, tokenSeparator)
+	for i, t := range tokens {
+		if len(t) == 0 {
+			return fmt.Errorf("invalid pattern %q: empty token", pattern)
+		}
+		if t == tokenRest && i != len(tokens)-1 {
+			return fmt.Errorf("invalid pattern %q: %s must be last token", pattern, tokenRest)
+		}
+	}
+	return nil
+}
+
+// MatchPattern reports whether channel name matches given pattern.
+func MatchPattern(pattern, name string) bool {
+	pt := strings.Split(pattern, tokenSeparator)
+	nt := strings.Split(name, tokenSeparator)
+	for i, p := range pt {
+		if p == tokenRest {
+			return len(nt) > i
+		}
+		if i >= len(nt) {
+			return false
+		}
+		if p != tokenAny && p != nt[i] {
+			return false
+		}
+	}
+	return len(pt) == len(nt)
+}
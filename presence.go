@@ -0,0 +1,151 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PresencePrefix is prepended to channel name to get its presence channel.
+const PresencePrefix = "presence."
+
+// PresenceChannel returns name of channel receiving join and leave events of given channel.
+func PresenceChannel(channel string) string {
+	return PresencePrefix + channel
+}
+
+// Presence actions.
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+// Member is identity of subscriber present in channel.
+type Member struct {
+	ID     string                 `json:"id"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+	Joined time.Time              `json:"joined"`
+}
+
+// PresenceEvent is published to presence channel when member joins or leaves channel.
+// Member subscribed several times joins on first subscription and leaves on last one.
+type PresenceEvent struct {
+	Action  string `json:"action"`
+	Channel string `json:"channel"`
+	Member  Member `json:"member"`
+}
+
+// ParsePresenceEvent converts payload received from presence channel to event.
+func ParsePresenceEvent(payload interface{}) (*PresenceEvent, error) {
+	switch e := payload.(type) {
+	case *PresenceEvent:
+		return e, nil
+	case PresenceEvent:
+		return &e, nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var e PresenceEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// PresenceReader is implemented by hubs tracking members of channels.
+type PresenceReader interface {
+	// Presence returns members currently subscribed to given channel.
+	Presence(ctx context.Context, channel string) ([]Member, error)
+}
+
+// SortMembers orders members by id, used by drivers.
+func SortMembers(list []Member) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+}
+
+// Channel member with number of its subscriptions.
+type presence struct {
+	member Member
+	count  int
+}
+
+// Registers member of given subscription, called within channel goroutine.
+func (c *channel) join(sub *sub) {
+	m := sub.options.Member
+	if m == nil || sub.options.Pattern {
+		return
+	}
+	if p, ok := c.members[m.ID]; ok {
+		p.count++
+		return
+	}
+	p := &presence{member: *m, count: 1}
+	p.member.Joined = time.Now().UTC()
+	c.members[m.ID] = p
+	c.publishPresence(PresenceJoin, p.member)
+}
+
+// Unregisters member of given subscription, called within channel goroutine.
+func (c *channel) leave(sub *sub) {
+	m := sub.options.Member
+	if m == nil || sub.options.Pattern {
+		return
+	}
+	p, ok := c.members[m.ID]
+	if !ok {
+		return
+	}
+	if p.count--; p.count > 0 {
+		return
+	}
+	delete(c.members, m.ID)
+	c.publishPresence(PresenceLeave, p.member)
+}
+
+// Publishes presence event asynchronously, so slow presence subscribers do not stall the channel.
+// Events are published in order, called within channel goroutine.
+func (c *channel) publishPresence(action string, m Member) {
+	e := &PresenceEvent{Action: action, Channel: c.name, Member: m}
+	prev := c.presenceEnd
+	end := make(chan struct{})
+	c.presenceEnd = end
+	go func() {
+		defer close(end)
+		if prev != nil {
+			<-prev
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), PublishTimeout)
+		defer cancel()
+		// ErrClosed is returned once hub is closed or shut down
+		if _, err := c.hub.PublishContext(ctx, []string{PresenceChannel(c.name)}, e); err != nil && err != ErrClosed {
+			log.Errorf("pubsub: publish presence of %s failed: %+v", c.name, err)
+		}
+	}()
+}
+
+// Presence returns members of given channel.
+func (hub *hub) Presence(ctx context.Context, channel string) ([]Member, error) {
+	cn, err := hub.getChannel(channel, false)
+	if err != nil || cn == nil {
+		return []Member{}, err
+	}
+	list := []Member{}
+	err = cn.Exec(ctx, func() {
+		for _, p := range cn.members {
+			list = append(list, p.member)
+		}
+	})
+	if err == ErrClosed && !hub.isClosed() {
+		// idle channel has been just removed
+		return []Member{}, nil
+	}
+	SortMembers(list)
+	return list, err
+}
@@ -2,7 +2,9 @@ package redis
 
 import (
 	"os"
+	"time"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/gocontrib/pubsub"
 	log "github.com/sirupsen/logrus"
 	"github.com/soveran/redisurl"
@@ -16,21 +18,51 @@ type driver struct{}
 
 func (d *driver) Create(config pubsub.HubConfig) (pubsub.Hub, error) {
 	log.Info("connecting to redis pubsub")
-	return Open(config.GetString("url", ""))
+	compress, err := pubsub.CompressionConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := pubsub.SignerConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return open(getRedisURL(config.GetString("url", "")), compress, signer)
 }
 
 // Open creates pubsub hub connected to redis server.
 func Open(URL ...string) (pubsub.Hub, error) {
-	redisURL := getRedisURL(URL...)
-	conn, err := redisurl.ConnectToURL(redisURL)
-	if err != nil {
+	return open(getRedisURL(URL...), pubsub.Compression{}, nil)
+}
+
+func open(redisURL string, compress pubsub.Compression, signer *pubsub.Signer) (pubsub.Hub, error) {
+	pool := &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redisurl.ConnectToURL(redisURL)
+		},
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		pool.Close()
 		return nil, err
 	}
-	return &hub{
-		conn:     conn,
+
+	h := &hub{
+		pool:     pool,
 		redisURL: redisURL,
 		subs:     make(map[*sub]struct{}),
-	}, nil
+		done:     make(chan struct{}),
+		compress: compress,
+		signer:   signer,
+
+		presenceHeartbeat: PresenceHeartbeat,
+		presenceTimeout:   PresenceTimeout,
+	}
+	go h.heartbeat()
+	return h, nil
 }
 
 func getRedisURL(URL ...string) string {
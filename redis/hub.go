@@ -1,70 +1,293 @@
 package redis
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/gocontrib/pubsub"
+	log "github.com/sirupsen/logrus"
 	"github.com/soveran/redisurl"
 )
 
 // pubsub hub powered by redis
 type hub struct {
 	sync.Mutex
-	conn     redis.Conn
+	pool     *redis.Pool
 	redisURL string
 	subs     map[*sub]struct{}
+	meters   pubsub.Meters
+	compress pubsub.Compression
+	signer   *pubsub.Signer
+	done     chan struct{}
+	once     sync.Once
+	closing  bool           // set by Shutdown, rejects publishes and subscriptions
+	inflight sync.WaitGroup // pending publishes, waited by Shutdown
+
+	presenceHeartbeat time.Duration
+	presenceTimeout   time.Duration
 }
 
 func (h *hub) Close() error {
+	h.once.Do(func() { close(h.done) })
+	h.Lock()
+	subs := make([]*sub, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.Unlock()
+	// members leave before pool is closed
+	for _, s := range subs {
+		s.leaveAll(h)
+	}
 	h.Lock()
 	defer h.Unlock()
 	for s := range h.subs {
 		s.Close()
 	}
-	return h.conn.Close()
+	return h.pool.Close()
 }
 
 func (h *hub) Publish(channels []string, msg interface{}) {
 	if len(channels) == 0 {
 		return
 	}
+	// registered before returning, so Shutdown waits for it
+	if !h.beginPublish() {
+		log.Errorf("redis publish failed: %+v", pubsub.ErrClosed)
+		return
+	}
 	go func() {
-		var data, err = pubsub.Marshal(msg)
+		defer h.inflight.Done()
+		_, err := h.publishContext(context.Background(), channels, msg)
 		if err != nil {
-			return
-		}
-		for _, name := range channels {
-			h.conn.Do("PUBLISH", name, data)
+			log.Errorf("redis publish failed: %+v", err)
 		}
 	}()
 }
 
-func (h *hub) Subscribe(channels []string) (pubsub.Channel, error) {
-	cn, err := redisurl.ConnectToURL(h.redisURL)
+func (h *hub) PublishContext(ctx context.Context, channels []string, msg interface{}, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+	if !h.beginPublish() {
+		return pubsub.PublishResult{}, pubsub.ErrClosed
+	}
+	defer h.inflight.Done()
+	return h.publishContext(ctx, channels, msg, opts...)
+}
+
+// Publishes message registered as pending publish.
+func (h *hub) publishContext(ctx context.Context, channels []string, msg interface{}, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+	var result pubsub.PublishResult
+	if len(channels) == 0 {
+		return result, nil
+	}
+
+	options := pubsub.MakePublishOptions(opts...)
+	env := pubsub.NewEnvelope(msg, opts...)
+	pubsub.InjectTrace(ctx, env)
+	defer func() { pubsub.ObservePublish("redis", result) }()
+
+	conn, err := h.pool.GetContext(ctx)
 	if err != nil {
-		return nil, err
+		return result, err
 	}
+	defer conn.Close()
 
-	var chans []interface{}
 	for _, name := range channels {
-		chans = append(chans, name)
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		data, err := h.compress.EncodeEnvelope(env.ForChannel(name))
+		if err == nil {
+			data, err = h.signer.Sign(data)
+		}
+		if err != nil {
+			return result, err
+		}
+		var n int
+		if options.Retain {
+			n, err = publishRetained(ctx, conn, name, data, env.TTL)
+		} else {
+			n, err = redis.Int(doContext(ctx, conn, "PUBLISH", name, data))
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return result, ctxErr
+		}
+		if err == nil {
+			h.meters.Mark(name)
+		}
+		result.Add(name, n, err)
 	}
 
-	h.Lock()
-	defer h.Unlock()
+	return result, result.Err()
+}
+
+// Stores message as retained value of channel and publishes it in one transaction.
+// Retained value expires with the message.
+func publishRetained(ctx context.Context, conn redis.Conn, channel string, data []byte, ttl time.Duration) (int, error) {
+	conn.Send("MULTI")
+	if ttl > 0 {
+		conn.Send("SET", retainedKey(channel), data, "PX", int64(ttl/time.Millisecond))
+	} else {
+		conn.Send("SET", retainedKey(channel), data)
+	}
+	conn.Send("PUBLISH", channel, data)
+	values, err := redis.Values(doContext(ctx, conn, "EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	if len(values) != 2 {
+		return 0, redis.ErrNil
+	}
+	return redis.Int(values[1], nil)
+}
+
+// Retained returns retained message of given channel or nil.
+func (h *hub) Retained(ctx context.Context, channel string) (*pubsub.Envelope, error) {
+	conn, err := h.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return getRetained(ctx, conn, h.signer, channel)
+}
+
+// ClearRetained removes retained message of given channel.
+func (h *hub) ClearRetained(ctx context.Context, channel string) error {
+	conn, err := h.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = doContext(ctx, conn, "DEL", retainedKey(channel))
+	return err
+}
+
+func (h *hub) Subscribe(channels []string) (pubsub.Channel, error) {
+	return h.SubscribeContext(context.Background(), channels)
+}
+
+func (h *hub) SubscribeContext(ctx context.Context, channels []string, opts ...pubsub.SubscribeOption) (pubsub.Channel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if h.isClosing() {
+		return nil, pubsub.ErrClosed
+	}
+
+	options := pubsub.MakeSubscribeOptions(opts...)
+	if err := options.Err(); err != nil {
+		return nil, err
+	}
+	if len(options.Group) > 0 {
+		// redis PUBLISH delivers every message to all subscribers
+		return nil, pubsub.ErrUnsupported
+	}
+	if options.HasReplay() {
+		// redis pubsub keeps no history
+		return nil, pubsub.ErrUnsupported
+	}
+
+	cn, err := redisurl.ConnectToURL(h.redisURL)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &sub{
 		hub:      h,
-		channels: chans,
+		pool:     h.pool,
+		pattern:  options.Pattern,
+		channels: make(map[string]bool),
+		globs:    make(map[string][]string),
 		conn:     redis.PubSubConn{Conn: cn},
+		created:  time.Now(),
+		member:   options.Member,
+		session:  pubsub.NewID(),
+		joined:   make(map[string]bool),
+		signer:   h.signer,
+		verifier: h.signer.NewVerifier(),
+		ready:    make(chan struct{}),
 		closed:   make(chan bool),
-		send:     make(chan interface{}),
 	}
+	s.inbox = pubsub.NewInbox(options, func() { s.Close() })
+
+	if err := s.Add(channels...); err != nil {
+		s.inbox.Close()
+		cn.Close()
+		return nil, err
+	}
+	s.pending = len(s.channels) + len(s.globs)
+
+	s.observed = pubsub.ObserveSubscription("redis")
+	h.Lock()
 	h.subs[s] = struct{}{}
+	h.Unlock()
 
 	go s.start()
-	return s, nil
+
+	select {
+	case <-s.ready:
+		if s.err != nil {
+			s.Close()
+			return nil, s.err
+		}
+		return s, nil
+	case <-ctx.Done():
+		s.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Shutdown stops accepting publishes and subscriptions, waits for pending publishes,
+// unsubscribes all subscriptions and delivers messages received before unsubscription.
+func (h *hub) Shutdown(ctx context.Context) error {
+	h.Lock()
+	h.closing = true
+	subs := make([]*sub, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.Unlock()
+
+	err := pubsub.WaitContext(ctx, &h.inflight)
+	if err == nil {
+		var wg sync.WaitGroup
+		errs := make(chan error, len(subs))
+		for _, s := range subs {
+			wg.Add(1)
+			go func(s *sub) {
+				defer wg.Done()
+				errs <- s.drain(ctx)
+			}(s)
+		}
+		wg.Wait()
+		close(errs)
+		for e := range errs {
+			if e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	h.Close()
+	return err
+}
+
+// Registers pending publish unless hub is shutting down.
+func (h *hub) beginPublish() bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.closing {
+		return false
+	}
+	h.inflight.Add(1)
+	return true
+}
+
+func (h *hub) isClosing() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.closing
 }
 
 func (h *hub) remove(s *sub) bool {
@@ -77,3 +300,64 @@ func (h *hub) remove(s *sub) bool {
 	delete(h.subs, s)
 	return true
 }
+
+// doContext executes redis command within deadline of given context.
+func doContext(ctx context.Context, conn redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return redis.DoWithTimeout(conn, time.Until(deadline), cmd, args...)
+	}
+	return conn.Do(cmd, args...)
+}
+
+// Prefix of keys storing retained messages.
+const retainedPrefix = "pubsub:retained:"
+
+func retainedKey(channel string) string {
+	return retainedPrefix + channel
+}
+
+func getRetained(ctx context.Context, conn redis.Conn, signer *pubsub.Signer, channel string) (*pubsub.Envelope, error) {
+	data, err := redis.Bytes(doContext(ctx, conn, "GET", retainedKey(channel)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// retained message is older than signature window
+	data, err = signer.Verify(data)
+	if err != nil {
+		return nil, err
+	}
+	env, err := pubsub.DecodeEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Channel) == 0 {
+		env.Channel = channel
+	}
+	return env, nil
+}
+
+// Returns channels having retained messages matching given redis glob.
+func scanRetained(conn redis.Conn, glob string) ([]string, error) {
+	var channels []string
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", retainedPrefix+glob))
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 2 {
+			return nil, redis.ErrNil
+		}
+		cursor, _ = redis.Int(values[0], nil)
+		keys, _ := redis.Strings(values[1], nil)
+		for _, k := range keys {
+			channels = append(channels, k[len(retainedPrefix):])
+		}
+		if cursor == 0 {
+			return channels, nil
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+	"sort"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gocontrib/pubsub"
+)
+
+// Channels returns channels having subscribers in the whole redis cluster
+// and channels recently published through this hub.
+// Subscribers of patterns are not counted.
+func (h *hub) Channels(ctx context.Context) ([]pubsub.ChannelInfo, error) {
+	conn, err := h.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	names, err := redis.Strings(doContext(ctx, conn, "PUBSUB", "CHANNELS"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range h.meters.Active() {
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []pubsub.ChannelInfo{}, nil
+	}
+	sort.Strings(names)
+
+	args := []interface{}{"NUMSUB"}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	values, err := redis.Values(doContext(ctx, conn, "PUBSUB", args...))
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(names))
+	for i := 0; i+1 < len(values); i += 2 {
+		name, _ := redis.String(values[i], nil)
+		n, _ := redis.Int(values[i+1], nil)
+		counts[name] = n
+	}
+
+	list := make([]pubsub.ChannelInfo, 0, len(names))
+	for _, name := range names {
+		list = append(list, pubsub.ChannelInfo{
+			Name:        name,
+			Subscribers: counts[name],
+			Rate:        h.meters.Rate(name),
+		})
+	}
+	return list, nil
+}
+
+// Subscriptions returns subscriptions opened through this hub.
+func (h *hub) Subscriptions(ctx context.Context) ([]pubsub.SubscriptionInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h.Lock()
+	subs := make([]*sub, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.Unlock()
+
+	list := make([]pubsub.SubscriptionInfo, 0, len(subs))
+	for _, s := range subs {
+		list = append(list, s.info())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"strings"
+
+	"github.com/gocontrib/pubsub"
+)
+
+// Converts pubsub pattern to redis glob-style pattern.
+// Glob is wider than original pattern, e.g. "a.*" also matches "a.b.c",
+// so received messages are verified with pubsub.MatchPattern.
+func toGlob(pattern string) string {
+	tokens := strings.Split(pattern, ".")
+	for i, t := range tokens {
+		switch t {
+		case "*", ">":
+			tokens[i] = "*"
+		default:
+			tokens[i] = globEscaper.Replace(t)
+		}
+	}
+	return strings.Join(tokens, ".")
+}
+
+var globEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`*`, `\*`,
+	`?`, `\?`,
+	`[`, `\[`,
+	`]`, `\]`,
+)
+
+// Makes map of redis globs to original patterns.
+func makeGlobs(patterns []string) (map[string][]string, error) {
+	globs := make(map[string][]string)
+	for _, p := range patterns {
+		if err := pubsub.ValidatePattern(p); err != nil {
+			return nil, err
+		}
+		g := toGlob(p)
+		globs[g] = append(globs[g], p)
+	}
+	return globs, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if pubsub.MatchPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) []string {
+	var rest []string
+	for _, t := range list {
+		if t != s {
+			rest = append(rest, t)
+		}
+	}
+	return rest
+}
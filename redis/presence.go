@@ -0,0 +1,235 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gocontrib/pubsub"
+	log "github.com/sirupsen/logrus"
+)
+
+// PresenceHeartbeat is interval of refreshing presence of local subscribers, read by Open.
+var PresenceHeartbeat = 5 * time.Second
+
+// PresenceTimeout is time after which members of crashed node leave channels, read by Open.
+var PresenceTimeout = 15 * time.Second
+
+// Prefix of keys storing presence of channels:
+// <prefix><channel> is sorted set of sessions scored by expiration time,
+// <prefix><channel>:members is hash of members by session,
+// <prefix><channel>:counts is hash of session counts by member id.
+const presencePrefix = "pubsub:presence:"
+
+func presenceKeys(channel string) []interface{} {
+	key := presencePrefix + channel
+	return []interface{}{key, key + ":members", key + ":counts"}
+}
+
+func (h *hub) deadline() int64 {
+	return time.Now().Add(h.presenceTimeout).UnixNano() / int64(time.Millisecond)
+}
+
+// Presence returns members subscribed to given channel in the whole cluster.
+func (h *hub) Presence(ctx context.Context, channel string) ([]pubsub.Member, error) {
+	conn, err := h.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := h.reap(ctx, conn, channel); err != nil {
+		return nil, err
+	}
+	values, err := redis.ByteSlices(doContext(ctx, conn, "HVALS", presenceKeys(channel)[1]))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]pubsub.Member)
+	for _, data := range values {
+		var m pubsub.Member
+		if err := json.Unmarshal(data, &m); err != nil {
+			continue
+		}
+		// member could be subscribed several times
+		if p, ok := byID[m.ID]; ok && p.Joined.Before(m.Joined) {
+			continue
+		}
+		byID[m.ID] = m
+	}
+	list := make([]pubsub.Member, 0, len(byID))
+	for _, m := range byID {
+		list = append(list, m)
+	}
+	pubsub.SortMembers(list)
+	return list, nil
+}
+
+// Registers session of member in given channel, publishes join event for its first session.
+func (h *hub) join(ctx context.Context, channel, session string, m pubsub.Member) error {
+	m.Joined = time.Now().UTC()
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	conn, err := h.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// sessions of crashed nodes should not hide join
+	if err := h.reap(ctx, conn, channel); err != nil {
+		return err
+	}
+	keys := presenceKeys(channel)
+	conn.Send("MULTI")
+	conn.Send("ZADD", keys[0], h.deadline(), session)
+	conn.Send("HSET", keys[1], session, data)
+	conn.Send("HINCRBY", keys[2], m.ID, 1)
+	values, err := redis.Values(doContext(ctx, conn, "EXEC"))
+	if err != nil {
+		return err
+	}
+	if len(values) != 3 {
+		return redis.ErrNil
+	}
+	if n, _ := redis.Int(values[2], nil); n == 1 {
+		h.publishPresence(ctx, pubsub.PresenceJoin, channel, m)
+	}
+	return nil
+}
+
+// Unregisters session of member in given channel, publishes leave event for its last session.
+func (h *hub) leave(ctx context.Context, channel, session string, m pubsub.Member) error {
+	conn, err := h.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return h.removeSession(ctx, conn, channel, session, &m)
+}
+
+// Removes expired sessions of given channel publishing leave events.
+func (h *hub) reap(ctx context.Context, conn redis.Conn, channel string) error {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	sessions, err := redis.Strings(doContext(ctx, conn, "ZRANGEBYSCORE", presenceKeys(channel)[0], "-inf", now))
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := h.removeSession(ctx, conn, channel, session, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes session of member, publishes leave event if it is the last session of member.
+// Member of expired session is loaded if nil.
+func (h *hub) removeSession(ctx context.Context, conn redis.Conn, channel, session string, m *pubsub.Member) error {
+	keys := presenceKeys(channel)
+	// only one node removing session updates member
+	removed, err := redis.Int(doContext(ctx, conn, "ZREM", keys[0], session))
+	if err != nil || removed == 0 {
+		return err
+	}
+	if m == nil {
+		data, err := redis.Bytes(doContext(ctx, conn, "HGET", keys[1], session))
+		if err == redis.ErrNil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m = &pubsub.Member{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return err
+		}
+	}
+	conn.Send("MULTI")
+	conn.Send("HDEL", keys[1], session)
+	conn.Send("HINCRBY", keys[2], m.ID, -1)
+	values, err := redis.Values(doContext(ctx, conn, "EXEC"))
+	if err != nil {
+		return err
+	}
+	if len(values) != 2 {
+		return redis.ErrNil
+	}
+	if n, _ := redis.Int(values[1], nil); n == 0 {
+		h.publishPresence(ctx, pubsub.PresenceLeave, channel, *m)
+	}
+	return nil
+}
+
+func (h *hub) publishPresence(ctx context.Context, action, channel string, m pubsub.Member) {
+	e := &pubsub.PresenceEvent{Action: action, Channel: channel, Member: m}
+	if _, err := h.PublishContext(ctx, []string{pubsub.PresenceChannel(channel)}, e); err != nil {
+		log.Errorf("redis: publish presence of %s failed: %+v", channel, err)
+	}
+}
+
+// Refreshes sessions of local subscribers and removes expired sessions of their channels.
+func (h *hub) heartbeat() {
+	ticker := time.NewTicker(h.presenceHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.done:
+			return
+		}
+
+		h.Lock()
+		subs := make([]*sub, 0, len(h.subs))
+		for s := range h.subs {
+			subs = append(subs, s)
+		}
+		h.Unlock()
+
+		sessions := make(map[string][]string)
+		for _, s := range subs {
+			for _, channel := range s.joinedChannels() {
+				sessions[channel] = append(sessions[channel], s.session)
+			}
+		}
+		if len(sessions) > 0 {
+			h.refresh(sessions)
+		}
+	}
+}
+
+// Updates expiration time of existing sessions only, ZADD XX requires redis 3.0.2.
+const refreshScript = `
+for i = 2, #ARGV do
+	if redis.call("ZSCORE", KEYS[1], ARGV[i]) then
+		redis.call("ZADD", KEYS[1], ARGV[1], ARGV[i])
+	end
+end
+return 0`
+
+func (h *hub) refresh(sessions map[string][]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.presenceHeartbeat)
+	defer cancel()
+	conn, err := h.pool.GetContext(ctx)
+	if err != nil {
+		log.Errorf("redis: presence heartbeat failed: %+v", err)
+		return
+	}
+	defer conn.Close()
+	for channel, list := range sessions {
+		args := []interface{}{refreshScript, 1, presenceKeys(channel)[0], h.deadline()}
+		for _, session := range list {
+			args = append(args, session)
+		}
+		if _, err := doContext(ctx, conn, "EVAL", args...); err != nil {
+			log.Errorf("redis: presence heartbeat failed: %+v", err)
+			return
+		}
+		if err := h.reap(ctx, conn, channel); err != nil {
+			log.Errorf("redis: presence reap failed: %+v", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gocontrib/pubsub"
+)
+
+// DefaultScheduleKey is name of sorted set keeping pending schedules.
+const DefaultScheduleKey = "pubsub:schedule"
+
+// schedules are kept in sorted set scored by due time in milliseconds,
+// their data is kept in hash with the same name and ":data" suffix
+type scheduleStore struct {
+	pool *redis.Pool
+	key  string
+}
+
+// NewScheduleStore creates store persisting schedules of pubsub.Scheduler
+// in redis server of given hub, hubs made by pubsub.Wrap are unwrapped.
+func NewScheduleStore(h pubsub.Hub, key string) (pubsub.ScheduleStore, error) {
+	for {
+		w, ok := h.(interface{ Unwrap() pubsub.Hub })
+		if !ok {
+			break
+		}
+		h = w.Unwrap()
+	}
+	rh, ok := h.(*hub)
+	if !ok {
+		return nil, errors.New("redis: not a redis hub")
+	}
+	if len(key) == 0 {
+		key = DefaultScheduleKey
+	}
+	return &scheduleStore{pool: rh.pool, key: key}, nil
+}
+
+func (s *scheduleStore) dataKey() string {
+	return s.key + ":data"
+}
+
+func (s *scheduleStore) Add(ctx context.Context, id string, at time.Time, data []byte) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("HSET", s.dataKey(), id, data)
+	conn.Send("ZADD", s.key, at.UnixNano()/int64(time.Millisecond), id)
+	_, err = doContext(ctx, conn, "EXEC")
+	return err
+}
+
+func (s *scheduleStore) Remove(ctx context.Context, id string) (bool, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("ZREM", s.key, id)
+	conn.Send("HDEL", s.dataKey(), id)
+	values, err := redis.Ints(doContext(ctx, conn, "EXEC"))
+	if err != nil {
+		return false, err
+	}
+	return len(values) > 0 && values[0] == 1, nil
+}
+
+func (s *scheduleStore) Load(ctx context.Context) ([][]byte, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ids, err := redis.Values(doContext(ctx, conn, "ZRANGE", s.key, 0, -1))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	args := append([]interface{}{s.dataKey()}, ids...)
+	values, err := redis.ByteSlices(doContext(ctx, conn, "HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	var list [][]byte
+	for _, data := range values {
+		if data != nil {
+			list = append(list, data)
+		}
+	}
+	return list, nil
+}
@@ -1,8 +1,11 @@
 package redis
 
 import (
+	"context"
 	"runtime/debug"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/gocontrib/pubsub"
@@ -13,41 +16,158 @@ import (
 type sub struct {
 	sync.Mutex
 	hub      *hub
-	channels []interface{}
+	pool     *redis.Pool
+	pattern  bool
+	channels map[string]bool
+	globs    map[string][]string
 	conn     redis.PubSubConn
+	pending  int
+	once     sync.Once
+	ready    chan struct{}
+	err      error
+	retained map[string]string // ids of delivered retained messages, used by start goroutine
+	created  time.Time
+	member   *pubsub.Member
+	session  string          // presence session id
+	joined   map[string]bool // channels member joined
+	observed func()
+	signer   *pubsub.Signer
+	verifier *pubsub.Verifier // checks signatures of received messages
+	drained  chan struct{}    // closed when server confirms unsubscription on shutdown
 	closed   chan bool
-	send     chan interface{}
+	inbox    *pubsub.Inbox
 }
 
 // Read returns channel of receiver events.
 func (s *sub) Read() <-chan interface{} {
-	return s.send
+	return s.inbox.Read()
+}
+
+// ReadEnvelope returns channel of receiver events with metadata.
+func (s *sub) ReadEnvelope() <-chan *pubsub.Envelope {
+	return s.inbox.ReadEnvelope()
+}
+
+// Add subscribes to given channels or patterns on existing connection.
+func (s *sub) Add(names ...string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.hub == nil || s.drained != nil {
+		return pubsub.ErrClosed
+	}
+
+	var args []interface{}
+	if s.pattern {
+		globs, err := makeGlobs(names)
+		if err != nil {
+			return err
+		}
+		for g, patterns := range globs {
+			if _, ok := s.globs[g]; !ok {
+				args = append(args, g)
+			}
+			s.globs[g] = append(s.globs[g], patterns...)
+		}
+	} else {
+		for _, name := range names {
+			if !s.channels[name] {
+				s.channels[name] = true
+				args = append(args, name)
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+	if s.pattern {
+		return s.conn.PSubscribe(args...)
+	}
+	return s.conn.Subscribe(args...)
+}
+
+// Remove unsubscribes from given channels or patterns.
+func (s *sub) Remove(names ...string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.hub == nil {
+		return pubsub.ErrClosed
+	}
+
+	var args []interface{}
+	if s.pattern {
+		for _, p := range names {
+			g := toGlob(p)
+			rest := remove(s.globs[g], p)
+			if len(rest) > 0 {
+				s.globs[g] = rest
+				continue
+			}
+			if _, ok := s.globs[g]; ok {
+				delete(s.globs, g)
+				args = append(args, g)
+			}
+		}
+	} else {
+		for _, name := range names {
+			if s.channels[name] {
+				delete(s.channels, name)
+				args = append(args, name)
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+	if s.pattern {
+		return s.conn.PUnsubscribe(args...)
+	}
+	return s.conn.Unsubscribe(args...)
 }
 
 // Close removes subscriber from channel.
 func (s *sub) Close() error {
 	go func() {
 		s.Lock()
-		defer s.Unlock()
-
 		if s.hub == nil {
+			s.Unlock()
 			return
 		}
 
-		s.hub.remove(s)
+		s.inbox.Stop()
+		h := s.hub
+		h.remove(s)
 		s.hub = nil
-		s.closed <- true
+		s.observed()
 
-		s.conn.Unsubscribe(s.channels...)
+		s.conn.Unsubscribe()
+		s.conn.PUnsubscribe()
 
 		// TODO safe stop of start goroutine
 		s.conn.Close()
+		s.Unlock()
 
-		close(s.send)
+		s.leaveAll(h)
+
+		s.closed <- true
+		s.inbox.Close()
 	}()
 	return nil
 }
 
+// Dropped returns number of events dropped because of buffer overflow.
+func (s *sub) Dropped() uint64 {
+	return s.inbox.Dropped()
+}
+
+// Expired returns number of events dropped because their TTL elapsed.
+func (s *sub) Expired() uint64 {
+	return s.inbox.Expired()
+}
+
 // CloseNotify returns channel to handle close event.
 func (s *sub) CloseNotify() <-chan bool {
 	return s.closed
@@ -59,31 +179,243 @@ func (s *sub) start() {
 		debug.PrintStack()
 	}
 
-	s.conn.Subscribe(s.channels...)
+	defer s.setReady(pubsub.ErrClosed)
+	defer s.setDrained()
+
+	if s.pending == 0 {
+		s.setReady(nil)
+	}
 
 	for {
 		switch m := s.conn.Receive().(type) {
 		case redis.Message:
-			s.push(m.Data)
+			s.push(m.Channel, m.Data)
 		case redis.PMessage:
-			s.push(m.Data)
+			if s.matchGlob(m.Pattern, m.Channel) {
+				s.push(m.Channel, m.Data)
+			}
 		case redis.Subscription:
-			log.Info("Subscription: %s %s %d", m.Kind, m.Channel, m.Count)
-			if m.Count == 0 {
-				return
+			log.Infof("Subscription: %s %s %d", m.Kind, m.Channel, m.Count)
+			switch m.Kind {
+			case "subscribe":
+				s.join(m.Channel)
+				s.pushRetained([]string{m.Channel})
+			case "unsubscribe":
+				s.leave(m.Channel)
+			case "psubscribe":
+				s.pushRetainedGlob(m.Channel)
+			}
+			if (m.Kind == "unsubscribe" || m.Kind == "punsubscribe") && m.Count == 0 {
+				s.setDrained()
+			}
+			if (m.Kind == "subscribe" || m.Kind == "psubscribe") && m.Count == s.pending {
+				s.setReady(nil)
 			}
 		case error:
+			s.setReady(m)
 			return
 		}
 	}
 }
 
-func (s *sub) push(data []byte) {
-	go func() {
-		v, err := pubsub.Unmarshal(data)
-		if err != nil {
+// setReady signals that subscription is confirmed by server or failed.
+func (s *sub) setReady(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.ready)
+	})
+}
+
+// Unsubscribes from all channels and waits until messages received before
+// server confirmation are read, then closes subscription.
+func (s *sub) drain(ctx context.Context) error {
+	s.Lock()
+	if s.hub == nil {
+		s.Unlock()
+		return nil
+	}
+	s.drained = make(chan struct{})
+	s.conn.Unsubscribe()
+	s.conn.PUnsubscribe()
+	s.Unlock()
+
+	defer s.Close()
+	select {
+	case <-s.drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.inbox.Drain(ctx)
+}
+
+// Signals that subscription has no channels left while draining.
+func (s *sub) setDrained() {
+	s.Lock()
+	defer s.Unlock()
+	if s.drained == nil {
+		return
+	}
+	select {
+	case <-s.drained:
+	default:
+		close(s.drained)
+	}
+}
+
+// Verifies that channel matches patterns of given redis glob.
+func (s *sub) matchGlob(glob, channel string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return matchAny(s.globs[glob], channel)
+}
+
+func (s *sub) push(channel string, data []byte) {
+	data, err := s.verifier.Verify(data)
+	if err != nil {
+		log.Errorf("redis: message of %s rejected: %v", channel, err)
+		return
+	}
+	env, err := pubsub.DecodeEnvelope(data)
+	if err != nil {
+		log.Errorf("redis: cannot decode message of %s: %v", channel, err)
+		return
+	}
+	if err := s.verifier.VerifyChannel(env, channel); err != nil {
+		log.Errorf("redis: message of %s signed for %s rejected: %v", channel, env.Channel, err)
+		return
+	}
+	if len(env.Channel) == 0 {
+		env.Channel = channel
+	}
+	if id, ok := s.retained[channel]; ok {
+		delete(s.retained, channel)
+		if env.ID == id {
+			// delivered already as retained message
 			return
 		}
-		s.send <- v
-	}()
+	}
+	s.inbox.Push(env)
+}
+
+// Delivers retained messages of just subscribed channels before any live message.
+func (s *sub) pushRetained(channels []string) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	for _, channel := range channels {
+		env, err := getRetained(context.Background(), conn, s.signer, channel)
+		if err != nil {
+			log.Errorf("redis: get retained message failed: %+v", err)
+			continue
+		}
+		if env == nil {
+			continue
+		}
+		if s.retained == nil {
+			s.retained = make(map[string]string)
+		}
+		// message published after subscription could be received again
+		s.retained[channel] = env.ID
+		s.inbox.Push(env)
+	}
+}
+
+func (s *sub) pushRetainedGlob(glob string) {
+	conn := s.pool.Get()
+	channels, err := scanRetained(conn, glob)
+	conn.Close()
+	if err != nil {
+		log.Errorf("redis: scan retained messages failed: %+v", err)
+		return
+	}
+	var matched []string
+	for _, channel := range channels {
+		if s.matchGlob(glob, channel) {
+			matched = append(matched, channel)
+		}
+	}
+	s.pushRetained(matched)
+}
+
+// Returns subscription info.
+func (s *sub) info() pubsub.SubscriptionInfo {
+	s.Lock()
+	defer s.Unlock()
+	info := pubsub.SubscriptionInfo{
+		Pattern: s.pattern,
+		Created: s.created,
+	}
+	for name := range s.channels {
+		info.Channels = append(info.Channels, name)
+	}
+	for _, patterns := range s.globs {
+		info.Channels = append(info.Channels, patterns...)
+	}
+	sort.Strings(info.Channels)
+	return info
+}
+
+// Joins member to given channel.
+func (s *sub) join(channel string) {
+	s.Lock()
+	h := s.hub
+	if s.member == nil || h == nil || s.joined[channel] {
+		s.Unlock()
+		return
+	}
+	s.joined[channel] = true
+	s.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pubsub.PublishTimeout)
+	defer cancel()
+	if err := h.join(ctx, channel, s.session, *s.member); err != nil {
+		log.Errorf("redis: join %s failed: %+v", channel, err)
+	}
+}
+
+// Removes member from given channel.
+func (s *sub) leave(channel string) {
+	s.Lock()
+	h := s.hub
+	if h == nil || !s.joined[channel] {
+		s.Unlock()
+		return
+	}
+	delete(s.joined, channel)
+	s.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pubsub.PublishTimeout)
+	defer cancel()
+	if err := h.leave(ctx, channel, s.session, *s.member); err != nil {
+		log.Errorf("redis: leave %s failed: %+v", channel, err)
+	}
+}
+
+// Removes member from all joined channels.
+func (s *sub) leaveAll(h *hub) {
+	s.Lock()
+	var channels []string
+	for channel := range s.joined {
+		channels = append(channels, channel)
+		delete(s.joined, channel)
+	}
+	s.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pubsub.PublishTimeout)
+	defer cancel()
+	for _, channel := range channels {
+		if err := h.leave(ctx, channel, s.session, *s.member); err != nil {
+			log.Errorf("redis: leave %s failed: %+v", channel, err)
+		}
+	}
+}
+
+// Returns channels member joined.
+func (s *sub) joinedChannels() []string {
+	s.Lock()
+	defer s.Unlock()
+	var channels []string
+	for channel := range s.joined {
+		channels = append(channels, channel)
+	}
+	return channels
 }
@@ -0,0 +1,175 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Headers used by request/reply.
+const (
+	HeaderReplyTo       = "reply-to"       // channel to send replies to
+	HeaderCorrelationID = "correlation-id" // id of request reply belongs to
+	HeaderError         = "error"          // error returned by responder
+)
+
+// DefaultRequestTimeout limits requests when context has no deadline.
+var DefaultRequestTimeout = 10 * time.Second
+
+// ReplyError is returned by Request when responder handler failed.
+type ReplyError struct {
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return e.Message
+}
+
+// RequestHandler handles request and returns reply message.
+type RequestHandler func(ctx context.Context, req *Envelope) (interface{}, error)
+
+// Prefix of reply channels made by NewReplyChannel.
+const replyPrefix = "_reply."
+
+// replyChannelMaker is implemented by hubs having native reply channels.
+type replyChannelMaker interface {
+	NewReplyChannel() string
+}
+
+// NewReplyChannel returns unique channel name to receive replies on given hub.
+func NewReplyChannel(hub Hub) string {
+	if m, ok := hub.(replyChannelMaker); ok {
+		return m.NewReplyChannel()
+	}
+	return replyPrefix + NewID()
+}
+
+// RPC implements request/reply on top of the hub.
+type RPC struct {
+	Hub     Hub
+	Timeout time.Duration // used when context has no deadline, DefaultRequestTimeout if zero
+}
+
+// Request sends message to given channel and waits for the first reply.
+func (r *RPC) Request(ctx context.Context, channel string, msg interface{}) (interface{}, error) {
+	replies, err := r.Gather(ctx, channel, msg, 1)
+	if err != nil {
+		return nil, err
+	}
+	return replies[0].Payload, nil
+}
+
+// Gather sends message to given channel and collects up to max replies (unlimited if max <= 0).
+// It stops when all known receivers replied or context is done.
+// Replies collected before the timeout are returned without error.
+func (r *RPC) Gather(ctx context.Context, channel string, msg interface{}, max int) ([]*Envelope, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := r.Timeout
+		if timeout <= 0 {
+			timeout = DefaultRequestTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	replyTo := NewReplyChannel(r.Hub)
+	s, err := r.Hub.SubscribeContext(ctx, []string{replyTo})
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	env := NewEnvelope(msg)
+	env.SetHeader(HeaderReplyTo, replyTo)
+	env.SetHeader(HeaderCorrelationID, env.ID)
+
+	result, err := r.Hub.PublishContext(ctx, []string{channel}, env)
+	if err != nil {
+		return nil, err
+	}
+	n := result.Receivers()
+	if n == 0 {
+		return nil, ErrNoResponders
+	}
+	if n > 0 && (max <= 0 || n < max) {
+		max = n
+	}
+
+	var replies []*Envelope
+	for max <= 0 || len(replies) < max {
+		select {
+		case reply, ok := <-s.ReadEnvelope():
+			if !ok {
+				return replies, ErrClosed
+			}
+			if id := reply.Header(HeaderCorrelationID); len(id) > 0 && id != env.ID {
+				continue
+			}
+			if msg := reply.Header(HeaderError); len(msg) > 0 {
+				if max == 1 {
+					return nil, &ReplyError{Message: msg}
+				}
+				log.Errorf("request to %s failed: %s", channel, msg)
+				continue
+			}
+			replies = append(replies, reply)
+		case <-ctx.Done():
+			if len(replies) > 0 {
+				return replies, nil
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return replies, nil
+}
+
+// Respond subscribes to given channels and replies to requests using given handler.
+// Close returned channel to stop responding.
+func (r *RPC) Respond(channels []string, handler RequestHandler, opts ...SubscribeOption) (Channel, error) {
+	s, err := r.Hub.SubscribeContext(context.Background(), channels, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for req := range s.ReadEnvelope() {
+			r.reply(req, handler)
+		}
+	}()
+	return s, nil
+}
+
+func (r *RPC) reply(req *Envelope, handler RequestHandler) {
+	ctx := context.Background()
+	msg, err := handler(ctx, req)
+
+	replyTo := req.Header(HeaderReplyTo)
+	if len(replyTo) == 0 {
+		if err != nil {
+			log.Errorf("request handler failed: %+v", err)
+		}
+		return
+	}
+
+	reply := NewEnvelope(msg)
+	id := req.Header(HeaderCorrelationID)
+	if len(id) == 0 {
+		id = req.ID
+	}
+	reply.SetHeader(HeaderCorrelationID, id)
+	if err != nil {
+		reply.Payload = nil
+		reply.SetHeader(HeaderError, err.Error())
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, err := r.Hub.PublishContext(ctx, []string{replyTo}, reply); err != nil {
+		log.Errorf("reply to %s failed: %+v", replyTo, err)
+	}
+}
@@ -0,0 +1,53 @@
+package pubsub
+
+import "fmt"
+
+// PublishResult describes outcome of publish operation.
+type PublishResult struct {
+	// Channels holds results in order of published channels.
+	Channels []ChannelResult
+}
+
+// ChannelResult describes outcome of publishing to single channel.
+type ChannelResult struct {
+	// Name of the channel.
+	Name string
+	// Receivers is number of subscribers received the message, -1 if unknown.
+	Receivers int
+	// Err is set when publishing to the channel failed.
+	Err error
+}
+
+// Add appends result for given channel.
+func (r *PublishResult) Add(name string, receivers int, err error) {
+	r.Channels = append(r.Channels, ChannelResult{
+		Name:      name,
+		Receivers: receivers,
+		Err:       err,
+	})
+}
+
+// Receivers returns total number of receivers or -1 if it is unknown for some channel.
+func (r PublishResult) Receivers() int {
+	total := 0
+	for _, c := range r.Channels {
+		if c.Err != nil {
+			continue
+		}
+		if c.Receivers < 0 {
+			return -1
+		}
+		total += c.Receivers
+	}
+	return total
+}
+
+// Err returns error of first failed channel.
+func (r PublishResult) Err() error {
+	for _, c := range r.Channels {
+		if c.Err != nil {
+			return fmt.Errorf("publish to %s failed: %w", c.Name, c.Err)
+		}
+	}
+	return nil
+}
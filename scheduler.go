@@ -0,0 +1,224 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNotScheduled is returned by Cancel for unknown or already published schedule.
+var ErrNotScheduled = errors.New("pubsub: message is not scheduled")
+
+// ScheduleStore persists pending schedules, so they survive restart.
+type ScheduleStore interface {
+	// Add stores schedule data due at given time.
+	Add(ctx context.Context, id string, at time.Time, data []byte) error
+	// Remove deletes schedule, reports whether it was stored.
+	// Only scheduler successfully removing schedule publishes it.
+	Remove(ctx context.Context, id string) (bool, error)
+	// Load returns data of all stored schedules.
+	Load(ctx context.Context) ([][]byte, error)
+}
+
+// Persisted schedule of delayed message.
+type schedule struct {
+	ID       string    `json:"id"`
+	At       time.Time `json:"at"`
+	Channels []string  `json:"channels"`
+	Retain   bool      `json:"retain,omitempty"`
+	Message  []byte    `json:"message"` // encoded envelope
+	env      *Envelope
+	timer    *time.Timer
+}
+
+// Scheduler publishes messages to the hub at given time, works with any driver.
+type Scheduler struct {
+	sync.Mutex
+	hub     Hub
+	store   ScheduleStore
+	pending map[string]*schedule
+	closed  bool
+}
+
+// PublishTimeout limits publishing of scheduled message.
+var PublishTimeout = 30 * time.Second
+
+// NewScheduler creates scheduler publishing to given hub.
+// Optional store persists pending schedules, stored schedules are loaded on start.
+func NewScheduler(hub Hub, store ScheduleStore) (*Scheduler, error) {
+	s := &Scheduler{
+		hub:     hub,
+		store:   store,
+		pending: make(map[string]*schedule),
+	}
+	if store == nil {
+		return s, nil
+	}
+
+	list, err := store.Load(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, data := range list {
+		var sc schedule
+		if err := json.Unmarshal(data, &sc); err != nil {
+			log.Errorf("pubsub: skip bad schedule: %+v", err)
+			continue
+		}
+		sc.env, err = DecodeEnvelope(sc.Message)
+		if err != nil {
+			log.Errorf("pubsub: skip bad schedule %s: %+v", sc.ID, err)
+			continue
+		}
+		s.start(&sc)
+	}
+	log.Infof("pubsub: loaded %d schedules", len(s.pending))
+	return s, nil
+}
+
+// PublishAt publishes message to given channels at given time,
+// returns handle to cancel publishing.
+func (s *Scheduler) PublishAt(at time.Time, channels []string, msg interface{}, opts ...PublishOption) (string, error) {
+	options := MakePublishOptions(opts...)
+	sc := &schedule{
+		ID:       NewID(),
+		At:       at,
+		Channels: channels,
+		Retain:   options.Retain,
+		env:      NewEnvelope(msg, opts...),
+	}
+
+	s.Lock()
+	closed := s.closed
+	s.Unlock()
+	if closed {
+		return "", ErrClosed
+	}
+
+	if s.store != nil {
+		var err error
+		sc.Message, err = EncodeEnvelope(sc.env)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(sc)
+		if err != nil {
+			return "", err
+		}
+		if err := s.store.Add(context.Background(), sc.ID, at, data); err != nil {
+			return "", err
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		// closed while storing
+		if s.store != nil {
+			if _, err := s.store.Remove(context.Background(), sc.ID); err != nil {
+				return "", err
+			}
+		}
+		return "", ErrClosed
+	}
+	s.start(sc)
+	return sc.ID, nil
+}
+
+// PublishAfter publishes message to given channels after given duration,
+// returns handle to cancel publishing.
+func (s *Scheduler) PublishAfter(d time.Duration, channels []string, msg interface{}, opts ...PublishOption) (string, error) {
+	return s.PublishAt(time.Now().Add(d), channels, msg, opts...)
+}
+
+// Cancel cancels publishing of scheduled message with given handle.
+func (s *Scheduler) Cancel(id string) error {
+	s.Lock()
+	sc, ok := s.pending[id]
+	if ok {
+		sc.timer.Stop()
+		delete(s.pending, id)
+	}
+	s.Unlock()
+
+	if s.store != nil {
+		removed, err := s.store.Remove(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		ok = ok || removed
+	}
+	if !ok {
+		return ErrNotScheduled
+	}
+	return nil
+}
+
+// Pending returns number of scheduled messages.
+func (s *Scheduler) Pending() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.pending)
+}
+
+// Close stops scheduler, stored schedules are published after restart.
+func (s *Scheduler) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	for id, sc := range s.pending {
+		sc.timer.Stop()
+		delete(s.pending, id)
+	}
+	return nil
+}
+
+// Starts timer of given schedule, called with locked mutex.
+func (s *Scheduler) start(sc *schedule) {
+	s.pending[sc.ID] = sc
+	sc.timer = time.AfterFunc(time.Until(sc.At), func() {
+		s.fire(sc)
+	})
+}
+
+func (s *Scheduler) fire(sc *schedule) {
+	s.Lock()
+	if s.pending[sc.ID] != sc {
+		// cancelled or closed
+		s.Unlock()
+		return
+	}
+	delete(s.pending, sc.ID)
+	s.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), PublishTimeout)
+	defer cancel()
+
+	if s.store != nil {
+		removed, err := s.store.Remove(ctx, sc.ID)
+		if err != nil {
+			log.Errorf("pubsub: remove schedule %s failed: %+v", sc.ID, err)
+			return
+		}
+		if !removed {
+			// cancelled or published by another scheduler
+			return
+		}
+	}
+
+	env := sc.env.ForChannel("")
+	env.Time = time.Now().UTC()
+	var opts []PublishOption
+	if sc.Retain {
+		opts = append(opts, Retain())
+	}
+	if _, err := s.hub.PublishContext(ctx, sc.Channels, env, opts...); err != nil {
+		log.Errorf("pubsub: scheduled publish to %v failed: %+v", sc.Channels, err)
+	}
+}
@@ -0,0 +1,77 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+)
+
+// Shutdowner is implemented by hubs able to close gracefully.
+type Shutdowner interface {
+	// Shutdown stops accepting publishes and subscriptions, waits for pending publishes,
+	// delivers already received messages and closes subscriptions and the hub.
+	// Hub is closed forcibly when context is done.
+	Shutdown(ctx context.Context) error
+}
+
+// ShutdownHub closes given hub gracefully if it is supported, otherwise closes it.
+func ShutdownHub(ctx context.Context, hub Hub) error {
+	if s, ok := hub.(Shutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	return hub.Close()
+}
+
+// WaitContext waits for given wait group until context is done, used by drivers.
+func WaitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown closes in-memory hub gracefully.
+func (hub *hub) Shutdown(ctx context.Context) error {
+	hub.Lock()
+	hub.closing = true
+	subs := make([]*sub, 0, len(hub.subs))
+	for s := range hub.subs {
+		subs = append(subs, s)
+	}
+	hub.Unlock()
+
+	err := WaitContext(ctx, &hub.publishing)
+	if err == nil {
+		err = drainSubs(ctx, subs)
+	}
+	hub.Close()
+	return err
+}
+
+// Closes subscriptions after their buffered messages are read.
+func drainSubs(ctx context.Context, subs []*sub) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(subs))
+	for _, s := range subs {
+		wg.Add(1)
+		go func(s *sub) {
+			defer wg.Done()
+			errs <- s.inbox.Drain(ctx)
+			s.Close()
+		}(s)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSignatureWindow is max difference between signing time and receive time.
+const DefaultSignatureWindow = 5 * time.Minute
+
+// Errors of message verification.
+var (
+	ErrUnsigned         = errors.New("pubsub: message is not signed")
+	ErrInvalidSignature = errors.New("pubsub: invalid message signature")
+	ErrReplayed         = errors.New("pubsub: replayed message")
+)
+
+// VerifyMode defines what happens with messages failed verification.
+type VerifyMode string
+
+// Supported verify modes.
+const (
+	VerifyReject VerifyMode = "reject" // drops message
+	VerifyLog    VerifyMode = "log"    // logs error and delivers message
+)
+
+// Signer signs encoded messages with HMAC-SHA256 using shared secrets identified by key id.
+// Signed message is magic prefix, line with key id, signing time, nonce and signature, and message itself.
+type Signer struct {
+	keys   map[string][]byte
+	keyID  string
+	mode   VerifyMode
+	window time.Duration
+}
+
+// NewSigner creates signer with given secrets, keyID names secret signing messages,
+// messages are only verified if it is empty.
+func NewSigner(keys map[string][]byte, keyID string, mode VerifyMode, window time.Duration) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("pubsub: no signing keys")
+	}
+	for id := range keys {
+		if len(id) == 0 || strings.ContainsAny(id, " \n") {
+			return nil, fmt.Errorf("pubsub: invalid signing key id %q", id)
+		}
+	}
+	if _, ok := keys[keyID]; len(keyID) > 0 && !ok {
+		return nil, fmt.Errorf("pubsub: unknown signing key %s", keyID)
+	}
+	switch mode {
+	case "":
+		mode = VerifyReject
+	case VerifyReject, VerifyLog:
+	default:
+		return nil, fmt.Errorf("pubsub: unknown verify mode %s", mode)
+	}
+	if window <= 0 {
+		window = DefaultSignatureWindow
+	}
+	return &Signer{keys: keys, keyID: keyID, mode: mode, window: window}, nil
+}
+
+// SignerConfig creates signer from signing_keys ("id:secret,..."), signing_key,
+// signing_mode and signing_window properties of hub config, returns nil if no keys are set.
+func SignerConfig(config HubConfig) (*Signer, error) {
+	s := config.GetString("signing_keys", "")
+	if len(s) == 0 {
+		return nil, nil
+	}
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.IndexByte(pair, ':')
+		if i <= 0 || i == len(pair)-1 {
+			return nil, fmt.Errorf("pubsub: invalid signing key %q", pair)
+		}
+		keys[strings.TrimSpace(pair[:i])] = []byte(pair[i+1:])
+	}
+	var window time.Duration
+	if w := config.GetString("signing_window", ""); len(w) > 0 {
+		d, err := time.ParseDuration(w)
+		if err != nil {
+			return nil, err
+		}
+		window = d
+	}
+	mode := VerifyMode(strings.ToLower(config.GetString("signing_mode", "")))
+	return NewSigner(keys, config.GetString("signing_key", ""), mode, window)
+}
+
+// Sign signs encoded message, returns message as is if signer is nil or has no signing key.
+func (s *Signer) Sign(data []byte) ([]byte, error) {
+	if s == nil || len(s.keyID) == 0 {
+		return data, nil
+	}
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	line := s.keyID + " " + strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10) + " " + hex.EncodeToString(nonce)
+	mac := signature(s.keys[s.keyID], line, data)
+
+	var buf bytes.Buffer
+	buf.Grow(len(signedMagic) + len(line) + 2 + base64.RawURLEncoding.EncodedLen(len(mac)) + len(data))
+	buf.Write(signedMagic)
+	buf.WriteString(line)
+	buf.WriteByte(' ')
+	buf.WriteString(base64.RawURLEncoding.EncodeToString(mac))
+	buf.WriteByte('\n')
+	buf.Write(data)
+	return buf.Bytes(), nil
+}
+
+// Verify checks signature of stored message ignoring its age, returns message without signature.
+// Messages failed verification are returned with error in reject mode.
+func (s *Signer) Verify(data []byte) ([]byte, error) {
+	if s == nil {
+		return unsign(data), nil
+	}
+	msg, _, err := s.verify(data)
+	return s.result(msg, err)
+}
+
+// NewVerifier creates verifier of messages received by subscription, nil if signer is nil.
+func (s *Signer) NewVerifier() *Verifier {
+	if s == nil {
+		return nil
+	}
+	return &Verifier{signer: s, nonces: make(map[string]time.Time)}
+}
+
+// Checks signature, returns message and its signing header.
+func (s *Signer) verify(data []byte) ([]byte, *signedHeader, error) {
+	h, msg, ok := parseSigned(data)
+	if !ok {
+		return msg, nil, ErrUnsigned
+	}
+	key, ok := s.keys[h.keyID]
+	if !ok || !hmac.Equal(h.mac, signature(key, h.line, msg)) {
+		return msg, nil, ErrInvalidSignature
+	}
+	return msg, h, nil
+}
+
+// Applies verify mode to verification error.
+func (s *Signer) result(msg []byte, err error) ([]byte, error) {
+	if err == nil {
+		return msg, nil
+	}
+	if s.mode == VerifyLog {
+		log.Warnf("%v, delivered anyway", err)
+		return msg, nil
+	}
+	switch err {
+	case ErrUnsigned:
+		droppedMessages.WithLabelValues("unsigned").Inc()
+	case ErrReplayed:
+		droppedMessages.WithLabelValues("replayed").Inc()
+	default:
+		droppedMessages.WithLabelValues("invalid_signature").Inc()
+	}
+	return nil, err
+}
+
+// Verifier checks signatures of messages received by subscription and rejects replayed messages,
+// i.e. messages signed out of time window or having nonce seen already.
+type Verifier struct {
+	signer *Signer
+	mutex  sync.Mutex
+	nonces map[string]time.Time // expiration time of seen nonces
+	pruned time.Time
+}
+
+// Verify checks received message, returns message without signature.
+// Messages failed verification are returned with error in reject mode.
+func (v *Verifier) Verify(data []byte) ([]byte, error) {
+	if v == nil {
+		return unsign(data), nil
+	}
+	msg, h, err := v.signer.verify(data)
+	if err == nil && !v.fresh(h) {
+		err = ErrReplayed
+	}
+	return v.signer.result(msg, err)
+}
+
+// VerifyChannel checks that verified message is received from the channel it was signed for,
+// so signed message could not be replayed to other channel. Nonces are remembered per subscription.
+// Message failed verification is reported with error in reject mode.
+func (v *Verifier) VerifyChannel(env *Envelope, channel string) error {
+	if v == nil || env.Channel == channel {
+		return nil
+	}
+	_, err := v.signer.result(nil, ErrReplayed)
+	return err
+}
+
+// Checks signing time and remembers nonce.
+func (v *Verifier) fresh(h *signedHeader) bool {
+	now := time.Now()
+	window := v.signer.window
+	if h.time.Before(now.Add(-window)) || h.time.After(now.Add(window)) {
+		return false
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if now.Sub(v.pruned) > window {
+		for nonce, exp := range v.nonces {
+			if exp.Before(now) {
+				delete(v.nonces, nonce)
+			}
+		}
+		v.pruned = now
+	}
+	key := h.keyID + " " + h.nonce
+	if _, seen := v.nonces[key]; seen {
+		return false
+	}
+	// older messages are rejected by time window
+	v.nonces[key] = h.time.Add(window)
+	return true
+}
+
+var signedMagic = []byte("PSS\n")
+
+type signedHeader struct {
+	line  string // signed part of header
+	keyID string
+	time  time.Time
+	nonce string
+	mac   []byte
+}
+
+// Splits signed message to header and message itself.
+func parseSigned(data []byte) (*signedHeader, []byte, bool) {
+	if !bytes.HasPrefix(data, signedMagic) {
+		return nil, data, false
+	}
+	data = data[len(signedMagic):]
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, data, false
+	}
+	fields := strings.Split(string(data[:i]), " ")
+	msg := data[i+1:]
+	if len(fields) != 4 {
+		return nil, msg, false
+	}
+	ms, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, msg, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(fields[3])
+	if err != nil {
+		return nil, msg, false
+	}
+	return &signedHeader{
+		line:  strings.Join(fields[:3], " "),
+		keyID: fields[0],
+		time:  time.Unix(0, ms*int64(time.Millisecond)),
+		nonce: fields[2],
+		mac:   mac,
+	}, msg, true
+}
+
+// Strips signature without verification.
+func unsign(data []byte) []byte {
+	_, msg, _ := parseSigned(data)
+	return msg
+}
+
+func signature(key []byte, line string, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(line))
+	m.Write([]byte{'\n'})
+	m.Write(data)
+	return m.Sum(nil)
+}
@@ -0,0 +1,17 @@
+package sse
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var connections = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "pubsub",
+	Subsystem: "sse",
+	Name:      "connections",
+	Help:      "Number of open event streams.",
+})
+
+// RegisterMetrics registers metrics of event streams with given registry.
+func RegisterMetrics(r prometheus.Registerer) error {
+	return r.Register(connections)
+}
@@ -15,14 +15,15 @@ import (
 
 // GetEventStream streams events in text/event-stream format.
 //
-// GET /api/event/stream/{channel}
+// GET /api/event/stream/{channel}?filter=<expression>
 //
 func GetEventStream(w http.ResponseWriter, r *http.Request) {
 	SendEvents(w, r, getChannels(r))
 }
 
-// SendEvents streams events from specified channels as Server Sent Events packets
-func SendEvents(w http.ResponseWriter, r *http.Request, channels []string) {
+// SendEvents streams events from specified channels as Server Sent Events packets.
+// Optional filter query parameter drops events not matching expression, see pubsub.FilterExpr.
+func SendEvents(w http.ResponseWriter, r *http.Request, channels []string, opts ...pubsub.SubscribeOption) {
 	// make sure that the writer supports flushing
 	flusher, ok := w.(http.Flusher)
 
@@ -39,13 +40,24 @@ func SendEvents(w http.ResponseWriter, r *http.Request, channels []string) {
 		return
 	}
 
-	sub, err := pubsub.Subscribe(channels)
+	if filter := strings.TrimSpace(r.URL.Query().Get("filter")); len(filter) > 0 {
+		if _, err := pubsub.ParseFilter(filter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts = append(opts, pubsub.Filter(filter))
+	}
+
+	sub, err := pubsub.SubscribeContext(r.Context(), channels, opts...)
 	if err != nil {
 		log.Error("subscribe failed: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	connections.Inc()
+	defer connections.Dec()
+
 	// set the headers related to event streaming
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-transform")
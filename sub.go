@@ -1,36 +1,102 @@
 package pubsub
 
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
 // Subscription to multiple hub channels.
 type sub struct {
-	channels []*channel
+	sync.Mutex
+	once     sync.Once
+	hub      *hub
+	options  SubscribeOptions
+	created  time.Time
+	channels map[string]*channel
+	observed func()
+	closing  bool
 	closed   chan bool
-	send     chan interface{}
+	inbox    *Inbox
 }
 
-func makeSub(channels []*channel) *sub {
-	return &sub{
-		channels: channels,
+func makeSub(hub *hub, options SubscribeOptions) *sub {
+	s := &sub{
+		hub:      hub,
+		options:  options,
+		created:  time.Now(),
+		channels: make(map[string]*channel),
 		closed:   make(chan bool),
-		send:     make(chan interface{}),
+		observed: ObserveSubscription("memory"),
 	}
+	s.inbox = NewInbox(options, func() { s.Close() })
+	return s
 }
 
 // Read returns channel of receiver events.
 func (s *sub) Read() <-chan interface{} {
-	return s.send
+	return s.inbox.Read()
+}
+
+// ReadEnvelope returns channel of receiver events with metadata.
+func (s *sub) ReadEnvelope() <-chan *Envelope {
+	return s.inbox.ReadEnvelope()
+}
+
+// Dropped returns number of events dropped because of buffer overflow.
+func (s *sub) Dropped() uint64 {
+	return s.inbox.Dropped()
+}
+
+// Expired returns number of events dropped because their TTL elapsed.
+func (s *sub) Expired() uint64 {
+	return s.inbox.Expired()
+}
+
+// Add subscribes to given channels or patterns.
+func (s *sub) Add(names ...string) error {
+	s.Lock()
+	closing := s.closing
+	s.Unlock()
+	if closing {
+		return ErrClosed
+	}
+	return s.subscribe(context.Background(), names)
+}
+
+// Remove unsubscribes from given channels or patterns.
+func (s *sub) Remove(names ...string) error {
+	var chans []*channel
+	if s.options.Pattern {
+		chans = s.hub.removePatterns(s, names)
+	} else {
+		chans = s.detachIf(func(name string) bool {
+			return contains(names, name)
+		})
+	}
+	// channel stops delivery to detached subscriber immediately,
+	// so it is safe to unregister asynchronously
+	go s.unsubscribe(chans)
+	return nil
 }
 
 // Close removes subscriber from channel.
 func (s *sub) Close() error {
-	go func() {
-		for _, c := range s.channels {
-			c.unsubscribe <- s
-		}
-	}()
-	go func() {
-		s.closed <- true
-		close(s.send)
-	}()
+	s.once.Do(func() {
+		s.hub.removeSub(s)
+		s.observed()
+		s.Lock()
+		s.closing = true
+		s.Unlock()
+		chans := s.detachIf(func(string) bool { return true })
+		s.inbox.Stop()
+		go func() {
+			s.unsubscribe(chans)
+			s.closed <- true
+			s.inbox.Close()
+		}()
+	})
 	return nil
 }
 
@@ -38,3 +104,105 @@ func (s *sub) Close() error {
 func (s *sub) CloseNotify() <-chan bool {
 	return s.closed
 }
+
+// Subscribes to given channels or patterns.
+func (s *sub) subscribe(ctx context.Context, names []string) error {
+	var chans []*channel
+	if s.options.Pattern {
+		for _, p := range names {
+			if err := ValidatePattern(p); err != nil {
+				return err
+			}
+		}
+		chans = s.hub.addPatterns(s, names)
+	} else {
+		for _, name := range names {
+			if err := s.join(ctx, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, cn := range chans {
+		err := cn.Subscribe(ctx, s)
+		if err == ErrClosed {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribes to channel with given name.
+func (s *sub) join(ctx context.Context, name string) error {
+	for {
+		cn, err := s.hub.getChannel(name, true)
+		if err != nil {
+			return err
+		}
+		if !s.attach(cn) {
+			return nil
+		}
+		err = cn.Subscribe(ctx, s)
+		if err == ErrClosed {
+			// idle channel has been just removed, try again
+			continue
+		}
+		return err
+	}
+}
+
+func (s *sub) unsubscribe(chans []*channel) {
+	for _, c := range chans {
+		select {
+		case c.unsubscribe <- s:
+		case <-c.done:
+		}
+	}
+}
+
+// Attaches channel, returns false if it is attached already or subscription is closed.
+func (s *sub) attach(c *channel) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.closing || s.channels[c.name] == c {
+		return false
+	}
+	s.channels[c.name] = c
+	return true
+}
+
+// Detaches channels which names satisfy given predicate.
+func (s *sub) detachIf(pred func(name string) bool) []*channel {
+	s.Lock()
+	defer s.Unlock()
+	var chans []*channel
+	for name, c := range s.channels {
+		if pred(name) {
+			chans = append(chans, c)
+			delete(s.channels, name)
+		}
+	}
+	return chans
+}
+
+// Reports whether channel is attached, checked before each delivery.
+func (s *sub) has(c *channel) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.channels[c.name] == c
+}
+
+// Returns sorted names of attached channels.
+func (s *sub) channelNames() []string {
+	s.Lock()
+	defer s.Unlock()
+	names := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
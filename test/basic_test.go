@@ -1,7 +1,10 @@
 package test
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/gocontrib/pubsub"
 )
@@ -9,3 +12,210 @@ import (
 func TestHub_Basic(t *testing.T) {
 	verifyBasicAPI(t, pubsub.NewHub())
 }
+
+func TestHub_PublishContext(t *testing.T) {
+	verifyPublishContext(t, pubsub.NewHub())
+}
+
+func TestHub_PatternSubscribe(t *testing.T) {
+	verifyPatternSubscribe(t, pubsub.NewHub())
+}
+
+func TestHub_Envelope(t *testing.T) {
+	verifyEnvelope(t, pubsub.NewHub())
+}
+
+func TestHub_Overflow(t *testing.T) {
+	verifyOverflow(t, pubsub.NewHub())
+}
+
+func TestHub_AddRemove(t *testing.T) {
+	verifyAddRemove(t, pubsub.NewHub())
+}
+
+func TestHub_Group(t *testing.T) {
+	verifyGroup(t, pubsub.NewHub())
+}
+
+func TestHub_Request(t *testing.T) {
+	verifyRequest(t, pubsub.NewHub())
+}
+
+func TestHub_History(t *testing.T) {
+	verifyHistory(t, pubsub.NewHub(pubsub.KeepHistory(100, 0)))
+}
+
+func TestHub_HistoryLimits(t *testing.T) {
+	hub := pubsub.NewHub(pubsub.KeepHistory(2, 50*time.Millisecond))
+	defer hub.Close()
+	h := hub.(pubsub.HistoryReader)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := hub.PublishContext(ctx, []string{"news"}, i)
+		ok(t, "PublishContext", err)
+	}
+
+	list, err := h.History(ctx, "news", 10)
+	ok(t, "History", err)
+	if len(list) != 2 || list[0].Payload != 1 {
+		t.Errorf("expected 2 last messages, got %+v", list)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	list, err = h.History(ctx, "news", 10)
+	ok(t, "History", err)
+	if len(list) != 0 {
+		t.Errorf("expected expired history, got %+v", list)
+	}
+}
+
+func TestHub_HistoryRelease(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channels := func(hub pubsub.Hub) []string {
+		list, err := hub.(pubsub.Inspector).Channels(ctx)
+		ok(t, "Channels", err)
+		var names []string
+		for _, c := range list {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	// channels are released when their history ages out
+	hub := pubsub.NewHub(pubsub.KeepHistory(10, 50*time.Millisecond))
+	defer hub.Close()
+	for i := 0; i < 200; i++ {
+		_, err := hub.PublishContext(ctx, []string{fmt.Sprintf("news.%d", i)}, i)
+		ok(t, "PublishContext", err)
+	}
+	if n := len(channels(hub)); n != 200 {
+		t.Errorf("expected channels keeping history, got %d", n)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(channels(hub)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if names := channels(hub); len(names) != 0 {
+		t.Errorf("expected expired channels to be released, got %d", len(names))
+	}
+
+	// reply channels keep no history
+	hub2 := pubsub.NewHub(pubsub.KeepHistory(10, 0))
+	defer hub2.Close()
+	rpc := &pubsub.RPC{Hub: hub2, Timeout: time.Second}
+	r, err := rpc.Respond([]string{"echo"}, func(ctx context.Context, req *pubsub.Envelope) (interface{}, error) {
+		return req.Payload, nil
+	})
+	ok(t, "Respond", err)
+	defer r.Close()
+	for i := 0; i < 50; i++ {
+		_, err := rpc.Request(ctx, "echo", i)
+		ok(t, "Request", err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for len(channels(hub2)) > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if names := channels(hub2); len(names) != 1 || names[0] != "echo" {
+		t.Errorf("expected only request channel, got %v", names)
+	}
+}
+
+func TestHub_Retained(t *testing.T) {
+	verifyRetained(t, pubsub.NewHub())
+}
+
+func TestHub_TTL(t *testing.T) {
+	verifyTTL(t, pubsub.NewHub(pubsub.KeepHistory(10, 0)))
+}
+
+func TestHub_Scheduler(t *testing.T) {
+	verifyScheduler(t, pubsub.NewHub(), nil)
+}
+
+func TestHub_Filter(t *testing.T) {
+	verifyFilter(t, pubsub.NewHub())
+}
+
+func TestHub_Wrap(t *testing.T) {
+	verifyWrap(t, pubsub.NewHub())
+}
+
+func TestHub_Inspector(t *testing.T) {
+	verifyInspector(t, pubsub.NewHub())
+}
+
+func TestHub_Presence(t *testing.T) {
+	verifyPresence(t, pubsub.NewHub())
+}
+
+func TestHub_PresenceSlowSubscriber(t *testing.T) {
+	hub := pubsub.NewHub()
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// presence subscriber never reads its events
+	slow, err := hub.SubscribeContext(ctx, []string{pubsub.PresenceChannel("room")}, pubsub.Buffer(1, pubsub.OverflowBlock))
+	ok(t, "SubscribeContext", err)
+	defer slow.Close()
+
+	s, err := hub.SubscribeContext(ctx, []string{"room"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		member, err := hub.SubscribeContext(ctx, []string{"room"}, pubsub.Identity(fmt.Sprint("user", i), nil))
+		ok(t, "SubscribeContext", err)
+		member.Close()
+	}
+
+	_, err = hub.PublishContext(ctx, []string{"room"}, "hello")
+	ok(t, "PublishContext", err)
+	select {
+	case msg := <-s.Read():
+		if msg != "hello" {
+			t.Errorf("unexpected message %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delivery is stalled by presence subscriber")
+	}
+}
+
+func TestHub_Metrics(t *testing.T) {
+	verifyMetrics(t, pubsub.NewHub(), "memory")
+}
+
+func TestHub_Trace(t *testing.T) {
+	verifyTrace(t, pubsub.NewHub())
+}
+
+func TestHub_Shutdown(t *testing.T) {
+	verifyShutdown(t, pubsub.NewHub())
+}
+
+func TestHub_ShutdownPublish(t *testing.T) {
+	verifyShutdownPublish(t, pubsub.NewHub())
+	verifyShutdownPublish(t, pubsub.Wrap(pubsub.NewHub(), pubsub.Middleware{}))
+}
+
+func TestHub_Encryption(t *testing.T) {
+	verifyEncryption(t, pubsub.NewHub())
+}
+
+func TestHub_Payloads(t *testing.T) {
+	verifyPayloads(t, pubsub.NewHub())
+}
+
+func TestHub_Types(t *testing.T) {
+	verifyTypes(t, pubsub.NewHub())
+}
+
+func TestHub_TypedEncryption(t *testing.T) {
+	verifyTypedEncryption(t, pubsub.NewHub())
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/gocontrib/pubsub"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, name := range []string{"json", "msgpack", "cbor", "gob"} {
+		env := pubsub.NewEnvelope(map[string]interface{}{"name": "bob", "tags": []interface{}{"a"}}, pubsub.ContentType(name))
+		codec := pubsub.GetCodec(name)
+		if ct := env.Header(pubsub.HeaderContentType); ct != codec.ContentType() {
+			t.Errorf("%s: expected content type %s, got %q", name, codec.ContentType(), ct)
+		}
+		data, err := pubsub.EncodeEnvelope(env)
+		ok(t, name+" EncodeEnvelope", err)
+		out, err := pubsub.DecodeEnvelope(data)
+		ok(t, name+" DecodeEnvelope", err)
+		m, isMap := out.Payload.(map[string]interface{})
+		if !isMap {
+			t.Errorf("%s: expected map payload, got %T", name, out.Payload)
+			continue
+		}
+		if m["name"] != "bob" || fmt.Sprint(m["tags"]) != "[a]" {
+			t.Errorf("%s: unexpected payload %+v", name, m)
+		}
+	}
+}
+
+func TestCodecProtobuf(t *testing.T) {
+	env := pubsub.NewEnvelope(&wrappers.StringValue{Value: "hello"}, pubsub.ContentType("protobuf"))
+	data, err := pubsub.EncodeEnvelope(env)
+	ok(t, "EncodeEnvelope", err)
+	out, err := pubsub.DecodeEnvelope(data)
+	ok(t, "DecodeEnvelope", err)
+
+	raw, isBytes := out.Payload.([]byte)
+	if !isBytes {
+		t.Fatalf("expected raw payload, got %T", out.Payload)
+	}
+	var msg wrappers.StringValue
+	ok(t, "Unmarshal", pubsub.GetCodec(pubsub.ContentTypeProtobuf).Unmarshal(raw, &msg))
+	if !proto.Equal(&msg, &wrappers.StringValue{Value: "hello"}) {
+		t.Errorf("unexpected message %v", msg)
+	}
+
+	// relayed message keeps encoded payload
+	again, err := pubsub.EncodeEnvelope(out)
+	ok(t, "EncodeEnvelope", err)
+	if string(again) != string(data) {
+		t.Error("expected relayed message to be encoded as is")
+	}
+
+	_, err = pubsub.EncodeEnvelope(pubsub.NewEnvelope("text", pubsub.ContentType("protobuf")))
+	if err == nil {
+		t.Error("expected error encoding non-proto message")
+	}
+}
+
+func TestCodecUnknown(t *testing.T) {
+	_, err := pubsub.EncodeEnvelope(pubsub.NewEnvelope("text", pubsub.ContentType("application/x-unknown")))
+	if err == nil {
+		t.Error("expected unknown content type error")
+	}
+	_, err = pubsub.MakeHub(pubsub.HubConfig{"driver": "nats", "codec": "unknown"})
+	if err == nil {
+		t.Error("expected unknown codec error")
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	for _, c := range []struct {
+		data string
+		want interface{}
+	}{
+		{`"test"`, "test"},
+		{`1.5`, 1.5},
+		{`[1,"a"]`, []interface{}{1.0, "a"}},
+		{`{"n":null}`, map[string]interface{}{"n": nil}},
+		{`null`, nil},
+		{`not json`, []byte("not json")},
+	} {
+		v, err := pubsub.Unmarshal([]byte(c.data))
+		ok(t, "Unmarshal", err)
+		if !reflect.DeepEqual(v, c.want) {
+			t.Errorf("Unmarshal(%s): expected %#v, got %#v", c.data, c.want, v)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package test
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gocontrib/pubsub"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func ok(t *testing.T, op string, err error) {
@@ -51,7 +57,1373 @@ func verifyBasicAPI(t *testing.T, hub pubsub.Hub) {
 
 	mustReceive(t, msgReceived)
 
+	if msg != "test" {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+
 	hub.Close()
 
 	mustReceive(t, closeReceived)
 }
+
+func verifyPublishContext(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s, err := hub.SubscribeContext(ctx, []string{"ctx"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	go func() {
+		for range s.Read() {
+		}
+	}()
+
+	result, err := hub.PublishContext(ctx, []string{"ctx", "nobody"}, "test")
+	ok(t, "PublishContext", err)
+
+	if len(result.Channels) != 2 {
+		t.Fatalf("expected 2 channel results, got %d", len(result.Channels))
+	}
+	if n := result.Receivers(); n >= 0 && n != 1 {
+		t.Errorf("expected 1 receiver, got %d", n)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = hub.PublishContext(cancelled, []string{"test"}, "test")
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	_, err = hub.SubscribeContext(cancelled, []string{"test"})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func verifyPatternSubscribe(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s, err := hub.SubscribeContext(ctx, []string{"orders.*", "audit.>"}, pubsub.Pattern())
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	published := make(chan bool)
+	go func() {
+		defer close(published)
+		for _, name := range []string{"orders.new", "orders.new.paid", "audit.a.b", "other"} {
+			_, err := hub.PublishContext(ctx, []string{name}, map[string]interface{}{"channel": name})
+			if err != nil {
+				t.Errorf("PublishContext failed: %+v", err)
+			}
+		}
+	}()
+
+	var received []string
+	for len(received) < 2 {
+		select {
+		case m := <-s.Read():
+			received = append(received, m.(map[string]interface{})["channel"].(string))
+		case <-ctx.Done():
+			t.Fatalf("timeout, received %v", received)
+		}
+	}
+	sort.Strings(received)
+	if received[0] != "audit.a.b" || received[1] != "orders.new" {
+		t.Errorf("unexpected messages: %v", received)
+	}
+
+	select {
+	case m := <-s.Read():
+		t.Errorf("unexpected message: %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mustReceive(t, published)
+}
+
+func verifyEnvelope(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s, err := hub.SubscribeContext(ctx, []string{"env.a", "env.b"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	published := make(chan bool)
+	go func() {
+		defer close(published)
+		env := &pubsub.Envelope{
+			ID:      "42",
+			Headers: map[string]string{"origin": "test"},
+			Payload: map[string]interface{}{"n": "a"},
+		}
+		if _, err := hub.PublishContext(ctx, []string{"env.a"}, env); err != nil {
+			t.Errorf("PublishContext failed: %+v", err)
+		}
+	}()
+
+	select {
+	case env := <-s.ReadEnvelope():
+		if env.Channel != "env.a" {
+			t.Errorf("unexpected channel: %s", env.Channel)
+		}
+		if env.ID != "42" {
+			t.Errorf("unexpected id: %s", env.ID)
+		}
+		if env.Time.IsZero() {
+			t.Error("publish time is not set")
+		}
+		if env.Header("origin") != "test" {
+			t.Errorf("unexpected headers: %v", env.Headers)
+		}
+		if env.Payload.(map[string]interface{})["n"] != "a" {
+			t.Errorf("unexpected payload: %+v", env.Payload)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	mustReceive(t, published)
+}
+
+func verifyOverflow(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, policy := range []pubsub.OverflowPolicy{pubsub.OverflowDropOldest, pubsub.OverflowDropNewest} {
+		s, err := hub.SubscribeContext(ctx, []string{"overflow"}, pubsub.Buffer(2, policy))
+		ok(t, "SubscribeContext", err)
+
+		for i := 0; i < 5; i++ {
+			_, err := hub.PublishContext(ctx, []string{"overflow"}, map[string]interface{}{"i": i})
+			ok(t, "PublishContext", err)
+		}
+
+		// buffer holds 2 messages, so 3 are dropped once drivers receive all messages
+		for deadline := time.Now().Add(time.Second); s.Dropped() < 3 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		var received []float64
+		for done := false; !done; {
+			select {
+			case env := <-s.ReadEnvelope():
+				v := env.Payload.(map[string]interface{})["i"]
+				switch n := v.(type) {
+				case int:
+					received = append(received, float64(n))
+				case float64:
+					received = append(received, n)
+				}
+			case <-time.After(100 * time.Millisecond):
+				done = true
+			}
+		}
+
+		if len(received) != 2 || s.Dropped() != 3 {
+			t.Fatalf("policy %d: received %v, dropped %d", policy, received, s.Dropped())
+		}
+		if policy == pubsub.OverflowDropOldest && fmt.Sprint(received) != "[3 4]" {
+			t.Errorf("policy %d: expected last messages to be kept, received %v", policy, received)
+		}
+		if policy == pubsub.OverflowDropNewest && fmt.Sprint(received) != "[0 1]" {
+			t.Errorf("policy %d: expected first messages to be kept, received %v", policy, received)
+		}
+		s.Close()
+	}
+
+	// latest value wins, also when reading payloads
+	s, err := hub.SubscribeContext(ctx, []string{"overflow"}, pubsub.Buffer(1, pubsub.OverflowDropOldest))
+	ok(t, "SubscribeContext", err)
+	values := s.Read()
+	for i := 0; i < 5; i++ {
+		_, err := hub.PublishContext(ctx, []string{"overflow"}, map[string]interface{}{"i": i})
+		ok(t, "PublishContext", err)
+	}
+	for deadline := time.Now().Add(time.Second); s.Dropped() < 4 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case v := <-values:
+		if i := fmt.Sprint(v.(map[string]interface{})["i"]); i != "4" {
+			t.Errorf("expected latest message, got %s, dropped %d", i, s.Dropped())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	s.Close()
+
+	s, err = hub.SubscribeContext(ctx, []string{"overflow"}, pubsub.Buffer(1, pubsub.OverflowDisconnect))
+	ok(t, "SubscribeContext", err)
+
+	for i := 0; i < 3; i++ {
+		_, err := hub.PublishContext(ctx, []string{"overflow"}, map[string]interface{}{"i": i})
+		ok(t, "PublishContext", err)
+	}
+
+	mustReceive(t, s.CloseNotify())
+}
+
+func verifyAddRemove(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s, err := hub.SubscribeContext(ctx, []string{"live.a"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	ok(t, "Add", s.Add("live.b"))
+
+	publish := func(name string) {
+		_, err := hub.PublishContext(ctx, []string{name}, map[string]interface{}{"channel": name})
+		ok(t, "PublishContext", err)
+	}
+	expect := func(name string) {
+		select {
+		case env := <-s.ReadEnvelope():
+			if env.Channel != name {
+				t.Errorf("expected message from %s, got from %s", name, env.Channel)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting message from %s", name)
+		}
+	}
+
+	publish("live.a")
+	expect("live.a")
+	publish("live.b")
+	expect("live.b")
+
+	ok(t, "Remove", s.Remove("live.a"))
+
+	// nats and redis process unsubscribe asynchronously
+	time.Sleep(50 * time.Millisecond)
+
+	publish("live.a")
+	publish("live.b")
+	expect("live.b")
+}
+
+func verifyGroup(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var members []pubsub.Channel
+	for i := 0; i < 2; i++ {
+		s, err := hub.SubscribeContext(ctx, []string{"jobs"}, pubsub.Group("workers"))
+		ok(t, "SubscribeContext", err)
+		defer s.Close()
+		members = append(members, s)
+	}
+	all, err := hub.SubscribeContext(ctx, []string{"jobs"})
+	ok(t, "SubscribeContext", err)
+	defer all.Close()
+
+	const total = 20
+	for i := 0; i < total; i++ {
+		_, err := hub.PublishContext(ctx, []string{"jobs"}, map[string]interface{}{"i": i})
+		ok(t, "PublishContext", err)
+	}
+
+	count := func(s pubsub.Channel) int {
+		n := 0
+		for {
+			select {
+			case <-s.ReadEnvelope():
+				n++
+			case <-time.After(100 * time.Millisecond):
+				return n
+			}
+		}
+	}
+
+	a, b := count(members[0]), count(members[1])
+	if a+b != total || a == 0 || b == 0 {
+		t.Errorf("expected %d messages shared by group members, got %d and %d", total, a, b)
+	}
+	if n := count(all); n != total {
+		t.Errorf("expected %d messages for regular subscriber, got %d", total, n)
+	}
+}
+
+func verifyRequest(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	rpc := &pubsub.RPC{Hub: hub, Timeout: time.Second}
+
+	for i := 1; i <= 2; i++ {
+		id := fmt.Sprint(i)
+		r, err := rpc.Respond([]string{"rpc"}, func(ctx context.Context, req *pubsub.Envelope) (interface{}, error) {
+			m := req.Payload.(map[string]interface{})
+			return map[string]interface{}{"echo": m["text"], "by": id}, nil
+		})
+		ok(t, "Respond", err)
+		defer r.Close()
+	}
+	r, err := rpc.Respond([]string{"rpc.fail"}, func(ctx context.Context, req *pubsub.Envelope) (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+	ok(t, "Respond", err)
+	defer r.Close()
+
+	req := map[string]interface{}{"text": "ping"}
+
+	reply, err := rpc.Request(context.Background(), "rpc", req)
+	ok(t, "Request", err)
+	if m, _ := reply.(map[string]interface{}); m == nil || m["echo"] != "ping" {
+		t.Errorf("unexpected reply: %+v", reply)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	replies, err := rpc.Gather(ctx, "rpc", req, 0)
+	ok(t, "Gather", err)
+	var by []string
+	for _, r := range replies {
+		by = append(by, fmt.Sprint(r.Payload.(map[string]interface{})["by"]))
+	}
+	sort.Strings(by)
+	if fmt.Sprint(by) != "[1 2]" {
+		t.Errorf("expected replies from both responders, got %v", by)
+	}
+
+	_, err = rpc.Request(context.Background(), "rpc.fail", req)
+	if e, ok := err.(*pubsub.ReplyError); !ok || e.Message != "boom" {
+		t.Errorf("expected reply error, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := rpc.Request(ctx, "rpc.nobody", req); err == nil {
+		t.Error("expected request without responders to fail")
+	}
+}
+
+// Verifies history of hub keeping at least 100 messages per channel.
+func verifyHistory(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	h, isReader := hub.(pubsub.HistoryReader)
+	if !isReader {
+		t.Fatal("hub does not implement HistoryReader")
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err := hub.PublishContext(ctx, []string{"news"}, i)
+		ok(t, "PublishContext", err)
+	}
+
+	list, err := h.History(ctx, "news", 3)
+	ok(t, "History", err)
+	if len(list) != 3 || list[0].Payload != 7 || list[2].Payload != 9 {
+		t.Fatalf("unexpected history: %+v", list)
+	}
+
+	list, err = h.HistorySince(ctx, "news", list[0].ID)
+	ok(t, "HistorySince", err)
+	if len(list) != 2 || list[0].Payload != 8 || list[1].Payload != 9 {
+		t.Fatalf("unexpected history since: %+v", list)
+	}
+
+	// replay while publishing must not produce gaps or duplicates
+	const total = 50
+	go func() {
+		for i := 0; i < total; i++ {
+			hub.PublishContext(ctx, []string{"live"}, i)
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	s, err := hub.SubscribeContext(ctx, []string{"live"}, pubsub.Replay(total))
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	for i := 0; i < total; i++ {
+		select {
+		case env := <-s.ReadEnvelope():
+			if env.Payload != i {
+				t.Fatalf("expected %d, got %+v", i, env.Payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %d", i)
+		}
+	}
+}
+
+func verifyRetained(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, isRetainer := hub.(pubsub.Retainer)
+	if !isRetainer {
+		t.Fatal("hub does not implement Retainer")
+	}
+	ok(t, "ClearRetained", r.ClearRetained(ctx, "status"))
+	ok(t, "ClearRetained", r.ClearRetained(ctx, "device.1"))
+
+	value := func(env *pubsub.Envelope) string {
+		if env == nil {
+			return "<nil>"
+		}
+		return fmt.Sprint(env.Payload.(map[string]interface{})["v"])
+	}
+	expect := func(s pubsub.Channel, v string) {
+		select {
+		case env := <-s.ReadEnvelope():
+			if value(env) != v {
+				t.Fatalf("expected %s, got %s", v, value(env))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %s", v)
+		}
+	}
+	expectNothing := func(s pubsub.Channel) {
+		select {
+		case env := <-s.ReadEnvelope():
+			t.Fatalf("unexpected message %s", value(env))
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	for i := 1; i <= 2; i++ {
+		_, err := hub.PublishContext(ctx, []string{"status", "device.1"}, map[string]interface{}{"v": i}, pubsub.Retain())
+		ok(t, "PublishContext", err)
+	}
+
+	s, err := hub.SubscribeContext(ctx, []string{"status"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+	expect(s, "2")
+
+	_, err = hub.PublishContext(ctx, []string{"status"}, map[string]interface{}{"v": 3})
+	ok(t, "PublishContext", err)
+	expect(s, "3")
+	expectNothing(s)
+
+	env, err := r.Retained(ctx, "status")
+	ok(t, "Retained", err)
+	if value(env) != "2" {
+		t.Errorf("expected retained 2, got %s", value(env))
+	}
+
+	p, err := hub.SubscribeContext(ctx, []string{"device.*"}, pubsub.Pattern())
+	ok(t, "SubscribeContext", err)
+	defer p.Close()
+	expect(p, "2")
+
+	ok(t, "ClearRetained", r.ClearRetained(ctx, "status"))
+	env, err = r.Retained(ctx, "status")
+	ok(t, "Retained", err)
+	if env != nil {
+		t.Errorf("expected no retained message, got %s", value(env))
+	}
+
+	s2, err := hub.SubscribeContext(ctx, []string{"status"})
+	ok(t, "SubscribeContext", err)
+	defer s2.Close()
+	expectNothing(s2)
+}
+
+func verifyTTL(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := hub.SubscribeContext(ctx, []string{"ttl"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	_, err = hub.PublishContext(ctx, []string{"ttl"}, map[string]interface{}{"v": 1}, pubsub.TTL(50*time.Millisecond))
+	ok(t, "PublishContext", err)
+	_, err = hub.PublishContext(ctx, []string{"ttl"}, map[string]interface{}{"v": 2}, pubsub.TTL(time.Minute))
+	ok(t, "PublishContext", err)
+
+	// nobody reads until first message expires
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case env := <-s.ReadEnvelope():
+		if v := fmt.Sprint(env.Payload.(map[string]interface{})["v"]); v != "2" {
+			t.Errorf("expected live message, got %s", v)
+		}
+		if env.TTL != time.Minute {
+			t.Errorf("expected ttl, got %v", env.TTL)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	if n := s.Expired(); n != 1 {
+		t.Errorf("expected 1 expired message, got %d", n)
+	}
+
+	h, isReader := hub.(pubsub.HistoryReader)
+	if !isReader {
+		return
+	}
+	list, err := h.History(ctx, "ttl", 10)
+	ok(t, "History", err)
+	if len(list) != 1 {
+		t.Errorf("expected expired message to be removed from history, got %+v", list)
+	}
+}
+
+// Verifies scheduler, stored schedules must survive scheduler restart if store is given.
+func verifyScheduler(t *testing.T, hub pubsub.Hub, store pubsub.ScheduleStore) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := hub.SubscribeContext(ctx, []string{"reminders"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	scheduler, err := pubsub.NewScheduler(hub, store)
+	ok(t, "NewScheduler", err)
+
+	start := time.Now()
+	_, err = scheduler.PublishAfter(100*time.Millisecond, []string{"reminders"}, map[string]interface{}{"v": "due"})
+	ok(t, "PublishAfter", err)
+	id, err := scheduler.PublishAfter(50*time.Millisecond, []string{"reminders"}, map[string]interface{}{"v": "cancelled"})
+	ok(t, "PublishAfter", err)
+	ok(t, "Cancel", scheduler.Cancel(id))
+	if err := scheduler.Cancel(id); err != pubsub.ErrNotScheduled {
+		t.Errorf("expected ErrNotScheduled, got %v", err)
+	}
+
+	expect := func(v string) {
+		select {
+		case env := <-s.ReadEnvelope():
+			if got := fmt.Sprint(env.Payload.(map[string]interface{})["v"]); got != v {
+				t.Fatalf("expected %s, got %s", v, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %s", v)
+		}
+	}
+
+	expect("due")
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("published too early: %v", elapsed)
+	}
+	if n := scheduler.Pending(); n != 0 {
+		t.Errorf("expected no pending schedules, got %d", n)
+	}
+
+	if store == nil {
+		scheduler.Close()
+		if _, err := scheduler.PublishAfter(0, []string{"reminders"}, "late"); err != pubsub.ErrClosed {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+		return
+	}
+
+	_, err = scheduler.PublishAfter(200*time.Millisecond, []string{"reminders"}, map[string]interface{}{"v": "restored"})
+	ok(t, "PublishAfter", err)
+	scheduler.Close()
+
+	// closed scheduler does not store schedules
+	if _, err := scheduler.PublishAfter(0, []string{"reminders"}, "late"); err != pubsub.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	list, err := store.Load(ctx)
+	ok(t, "Load", err)
+	if len(list) != 1 {
+		t.Errorf("expected only pending schedule in store, got %d", len(list))
+	}
+
+	scheduler, err = pubsub.NewScheduler(hub, store)
+	ok(t, "NewScheduler", err)
+	defer scheduler.Close()
+	expect("restored")
+}
+
+func verifyFilter(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := hub.SubscribeContext(ctx, []string{"global"}, pubsub.Filter(`action ==`))
+	if err == nil {
+		t.Error("expected invalid filter to fail subscription")
+	}
+
+	s, err := hub.SubscribeContext(ctx, []string{"global"}, pubsub.Filter(`resource_type == "task" && action != "GET"`))
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	for _, e := range []*pubsub.Event{
+		{ID: "1", Action: "GET", ResourceType: "task"},
+		{ID: "2", Action: "POST", ResourceType: "user"},
+		{ID: "3", Action: "POST", ResourceType: "task"},
+	} {
+		_, err := hub.PublishContext(ctx, []string{"global"}, e)
+		ok(t, "PublishContext", err)
+	}
+
+	third, err := pubsub.ParseFilter(`id == "3"`)
+	ok(t, "ParseFilter", err)
+
+	select {
+	case env := <-s.ReadEnvelope():
+		if !third.Match(env) {
+			t.Errorf("expected event 3, got %+v", env.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case env := <-s.ReadEnvelope():
+		t.Errorf("unexpected event %+v", env.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func verifyWrap(t *testing.T, hub pubsub.Hub) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	trace := func(name string) pubsub.Middleware {
+		return pubsub.Middleware{
+			Publish: func(next pubsub.PublishFunc) pubsub.PublishFunc {
+				return func(ctx context.Context, channels []string, env *pubsub.Envelope, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+					if env.Header("reject") == name {
+						return pubsub.PublishResult{}, fmt.Errorf("rejected by %s", name)
+					}
+					env.SetHeader("trace", env.Header("trace")+name)
+					return next(ctx, channels, env, opts...)
+				}
+			},
+			Deliver: func(next pubsub.DeliverFunc) pubsub.DeliverFunc {
+				return func(env *pubsub.Envelope) {
+					if env.Header("drop") == name {
+						return
+					}
+					env.SetHeader("trace", env.Header("trace")+name)
+					next(env)
+				}
+			},
+		}
+	}
+
+	hub = pubsub.Wrap(hub, trace("a"), trace("b"))
+	defer hub.Close()
+
+	s, err := hub.SubscribeContext(ctx, []string{"wrap"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	publish := func(key, value string) error {
+		env := &pubsub.Envelope{Payload: map[string]interface{}{"v": value}}
+		if len(key) > 0 {
+			env.SetHeader(key, value)
+		}
+		_, err := hub.PublishContext(ctx, []string{"wrap"}, env)
+		return err
+	}
+
+	if err := publish("reject", "b"); err == nil || err.Error() != "rejected by b" {
+		t.Errorf("expected rejection, got %v", err)
+	}
+	ok(t, "PublishContext", publish("drop", "a"))
+	ok(t, "PublishContext", publish("", "pass"))
+
+	select {
+	case env := <-s.ReadEnvelope():
+		if v := env.Payload.(map[string]interface{})["v"]; v != "pass" {
+			t.Errorf("unexpected message %v", v)
+		}
+		if tr := env.Header("trace"); tr != "abab" {
+			t.Errorf("expected trace abab, got %s", tr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case env := <-s.ReadEnvelope():
+		t.Errorf("unexpected message %+v", env)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func verifyInspector(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	inspector, isInspector := hub.(pubsub.Inspector)
+	if !isInspector {
+		t.Fatal("expected hub to implement Inspector")
+	}
+
+	for i := 0; i < 2; i++ {
+		s, err := hub.SubscribeContext(ctx, []string{"inspect"})
+		ok(t, "SubscribeContext", err)
+		defer s.Close()
+	}
+	for i := 0; i < 5; i++ {
+		_, err := hub.PublishContext(ctx, []string{"inspect"}, map[string]interface{}{"n": i})
+		ok(t, "PublishContext", err)
+	}
+
+	channels, err := inspector.Channels(ctx)
+	ok(t, "Channels", err)
+	var found bool
+	for _, c := range channels {
+		if c.Name != "inspect" {
+			continue
+		}
+		found = true
+		if c.Subscribers < 2 {
+			t.Errorf("expected 2 subscribers, got %d", c.Subscribers)
+		}
+		if c.Rate <= 0 {
+			t.Errorf("expected positive rate, got %v", c.Rate)
+		}
+	}
+	if !found {
+		t.Errorf("channel is not listed: %+v", channels)
+	}
+
+	subs, err := inspector.Subscriptions(ctx)
+	ok(t, "Subscriptions", err)
+	n := 0
+	for _, s := range subs {
+		if len(s.Channels) == 1 && s.Channels[0] == "inspect" {
+			n++
+			if s.Age() <= 0 {
+				t.Errorf("expected positive age, got %v", s.Age())
+			}
+		}
+	}
+	if n != 2 {
+		t.Errorf("expected 2 subscriptions, got %+v", subs)
+	}
+}
+
+func verifyPresence(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reader, isReader := hub.(pubsub.PresenceReader)
+	if !isReader {
+		t.Fatal("expected hub to implement PresenceReader")
+	}
+
+	// presence is shared by redis clients, so channel should be unique
+	room := "room." + pubsub.NewID()
+	events, err := hub.SubscribeContext(ctx, []string{pubsub.PresenceChannel(room)})
+	ok(t, "SubscribeContext", err)
+	defer events.Close()
+
+	expect := func(action, id string) {
+		select {
+		case env := <-events.ReadEnvelope():
+			e, err := pubsub.ParsePresenceEvent(env.Payload)
+			ok(t, "ParsePresenceEvent", err)
+			if e.Action != action || e.Member.ID != id || e.Channel != room {
+				t.Errorf("expected %s of %s, got %+v", action, id, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting %s of %s", action, id)
+		}
+	}
+	members := func(ids ...string) {
+		list, err := reader.Presence(ctx, room)
+		ok(t, "Presence", err)
+		var got []string
+		for _, m := range list {
+			got = append(got, m.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Errorf("expected members %v, got %v", ids, got)
+		}
+	}
+
+	alice1, err := hub.SubscribeContext(ctx, []string{room}, pubsub.Identity("alice", map[string]interface{}{"name": "Alice"}))
+	ok(t, "SubscribeContext", err)
+	expect(pubsub.PresenceJoin, "alice")
+	alice2, err := hub.SubscribeContext(ctx, []string{room}, pubsub.Identity("alice", nil))
+	ok(t, "SubscribeContext", err)
+	bob, err := hub.SubscribeContext(ctx, []string{room}, pubsub.Identity("bob", nil))
+	ok(t, "SubscribeContext", err)
+	defer bob.Close()
+	expect(pubsub.PresenceJoin, "bob")
+
+	members("alice", "bob")
+	list, err := reader.Presence(ctx, room)
+	ok(t, "Presence", err)
+	if list[0].Meta["name"] != "Alice" {
+		t.Errorf("expected member meta, got %+v", list[0])
+	}
+
+	// member leaves on last subscription
+	alice1.Close()
+	alice2.Close()
+	expect(pubsub.PresenceLeave, "alice")
+	members("bob")
+}
+
+func verifyMetrics(t *testing.T, hub pubsub.Hub, driver string) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	ok(t, "RegisterMetrics", pubsub.RegisterMetrics(reg))
+
+	// metrics are global, so only increments are verified
+	metric := func(name string, labels ...string) float64 {
+		families, err := reg.Gather()
+		ok(t, "Gather", err)
+		for _, f := range families {
+			if f.GetName() != name {
+				continue
+			}
+		next:
+			for _, m := range f.GetMetric() {
+				for i, l := range m.GetLabel() {
+					if i*2+1 >= len(labels) || l.GetName() != labels[i*2] || l.GetValue() != labels[i*2+1] {
+						continue next
+					}
+				}
+				if c := m.GetCounter(); c != nil {
+					return c.GetValue()
+				}
+				return m.GetGauge().GetValue()
+			}
+		}
+		return 0
+	}
+
+	published := metric("pubsub_published_messages_total", "driver", driver)
+	delivered := metric("pubsub_delivered_messages_total")
+	subscriptions := metric("pubsub_subscriptions", "driver", driver)
+
+	s, err := hub.SubscribeContext(ctx, []string{"metrics"})
+	ok(t, "SubscribeContext", err)
+	if n := metric("pubsub_subscriptions", "driver", driver); n != subscriptions+1 {
+		t.Errorf("expected %v subscriptions, got %v", subscriptions+1, n)
+	}
+
+	_, err = hub.PublishContext(ctx, []string{"metrics"}, map[string]interface{}{"n": 1})
+	ok(t, "PublishContext", err)
+	select {
+	case <-s.Read():
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+
+	if n := metric("pubsub_published_messages_total", "driver", driver); n != published+1 {
+		t.Errorf("expected %v published messages, got %v", published+1, n)
+	}
+	if n := metric("pubsub_delivered_messages_total"); n < delivered+1 {
+		t.Errorf("expected at least %v delivered messages, got %v", delivered+1, n)
+	}
+
+	s.Close()
+	<-s.CloseNotify()
+	if n := metric("pubsub_subscriptions", "driver", driver); n != subscriptions {
+		t.Errorf("expected %v subscriptions, got %v", subscriptions, n)
+	}
+}
+
+func verifyTrace(t *testing.T, hub pubsub.Hub) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	parent, err := pubsub.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ok(t, "ParseTraceParent", err)
+	parent.State = "vendor=value"
+	pctx := pubsub.ContextWithSpan(ctx, parent)
+
+	receive := func(s pubsub.Channel) *pubsub.Envelope {
+		select {
+		case env := <-s.ReadEnvelope():
+			return env
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		return nil
+	}
+
+	// every driver carries trace context of publisher
+	s, err := hub.SubscribeContext(ctx, []string{"trace"})
+	ok(t, "SubscribeContext", err)
+	_, err = hub.PublishContext(pctx, []string{"trace"}, map[string]interface{}{"n": 1})
+	ok(t, "PublishContext", err)
+	sc, found := pubsub.SpanFromContext(pubsub.ExtractTrace(ctx, receive(s)))
+	if !found || sc.TraceID != parent.TraceID || sc.SpanID != parent.SpanID || sc.State != parent.State {
+		t.Errorf("expected span %+v, got %+v", parent, sc)
+	}
+	s.Close()
+
+	recorder := &pubsub.SpanRecorder{}
+	hub = pubsub.Wrap(hub, pubsub.Tracing(recorder))
+	defer hub.Close()
+
+	s, err = hub.SubscribeContext(ctx, []string{"trace"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+	_, err = hub.PublishContext(pctx, []string{"trace"}, map[string]interface{}{"n": 2})
+	ok(t, "PublishContext", err)
+	sc, found = pubsub.SpanFromContext(pubsub.ExtractTrace(ctx, receive(s)))
+
+	spans := recorder.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected publish and receive spans, got %+v", spans)
+	}
+	// receive span could end before publish span
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Kind < spans[j].Kind })
+	producer, consumer := spans[0], spans[1]
+	if producer.Kind != pubsub.SpanKindProducer || producer.Name != "trace publish" || producer.Parent.SpanID != parent.SpanID {
+		t.Errorf("unexpected publish span %+v", producer)
+	}
+	if consumer.Kind != pubsub.SpanKindConsumer || consumer.Name != "trace receive" || consumer.Parent.SpanID != producer.SpanContext.SpanID {
+		t.Errorf("unexpected receive span %+v", consumer)
+	}
+	if producer.SpanContext.TraceID != parent.TraceID || consumer.SpanContext.TraceID != parent.TraceID {
+		t.Error("expected spans to continue trace")
+	}
+	if !found || sc.SpanID != consumer.SpanContext.SpanID {
+		t.Errorf("expected receiver to continue receive span, got %+v", sc)
+	}
+}
+
+func verifyShutdown(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channel := "drain." + pubsub.NewID()
+	s, err := hub.SubscribeContext(ctx, []string{channel})
+	ok(t, "SubscribeContext", err)
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		_, err = hub.PublishContext(ctx, []string{channel}, map[string]interface{}{"v": i})
+		ok(t, "PublishContext", err)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- hub.(pubsub.Shutdowner).Shutdown(ctx)
+	}()
+
+	// subscriber reads messages after shutdown is started
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown finished before messages are read: %v", err)
+	default:
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case env := <-s.ReadEnvelope():
+			if v := fmt.Sprint(env.Payload.(map[string]interface{})["v"]); v != fmt.Sprint(i) {
+				t.Errorf("expected message %d, got %s", i, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout, received %d messages", i)
+		}
+	}
+	mustReceive(t, s.CloseNotify())
+
+	select {
+	case err := <-shutdown:
+		ok(t, "Shutdown", err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown timeout")
+	}
+
+	_, err = hub.PublishContext(ctx, []string{channel}, "late")
+	if err != pubsub.ErrClosed {
+		t.Errorf("expected ErrClosed on publish, got %v", err)
+	}
+	_, err = hub.SubscribeContext(ctx, []string{channel})
+	if err != pubsub.ErrClosed {
+		t.Errorf("expected ErrClosed on subscribe, got %v", err)
+	}
+}
+
+// Verifies that Shutdown flushes messages sent with Publish just before it.
+func verifyShutdownPublish(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channel := "flush." + pubsub.NewID()
+	s, err := hub.SubscribeContext(ctx, []string{channel})
+	ok(t, "SubscribeContext", err)
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		hub.Publish([]string{channel}, map[string]interface{}{"v": i})
+	}
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- hub.(pubsub.Shutdowner).Shutdown(ctx)
+	}()
+
+	// Publish sends messages concurrently
+	received := make(map[string]bool)
+	for len(received) < n {
+		select {
+		case env := <-s.ReadEnvelope():
+			received[fmt.Sprint(env.Payload.(map[string]interface{})["v"])] = true
+		case <-time.After(time.Second):
+			t.Fatalf("timeout, received %d messages", len(received))
+		}
+	}
+	mustReceive(t, s.CloseNotify())
+	ok(t, "Shutdown", <-shutdown)
+}
+
+func verifyCodecs(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channel := "codec." + pubsub.NewID()
+	s, err := hub.SubscribeContext(ctx, []string{channel})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	// producers with different codecs share channel
+	names := []string{"json", "msgpack", "cbor", "gob"}
+	for i, name := range names {
+		_, err = hub.PublishContext(ctx, []string{channel}, map[string]interface{}{"v": i}, pubsub.ContentType(name))
+		ok(t, "PublishContext", err)
+	}
+
+	for i, name := range names {
+		select {
+		case env := <-s.ReadEnvelope():
+			if ct := env.Header(pubsub.HeaderContentType); ct != pubsub.GetCodec(name).ContentType() {
+				t.Errorf("expected %s content type, got %q", name, ct)
+			}
+			if v := fmt.Sprint(env.Payload.(map[string]interface{})["v"]); v != fmt.Sprint(i) {
+				t.Errorf("%s: expected %d, got %s", name, i, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func verifyCompression(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channel := "compression." + pubsub.NewID()
+	s, err := hub.SubscribeContext(ctx, []string{channel})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	payloads := []string{strings.Repeat("snapshot ", 1000), "small"}
+	for _, text := range payloads {
+		_, err = hub.PublishContext(ctx, []string{channel}, map[string]interface{}{"text": text})
+		ok(t, "PublishContext", err)
+	}
+
+	for _, text := range payloads {
+		select {
+		case msg := <-s.Read():
+			if msg.(map[string]interface{})["text"] != text {
+				t.Errorf("unexpected payload of %d bytes", len(fmt.Sprint(msg)))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func verifyEncryption(t *testing.T, hub pubsub.Hub) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keyring, err := pubsub.NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	ok(t, "NewKeyring", err)
+
+	secret := "secret." + pubsub.NewID()
+	public := "public." + pubsub.NewID()
+
+	// broker side sees raw messages
+	raw, err := hub.SubscribeContext(ctx, []string{secret, public})
+	ok(t, "SubscribeContext", err)
+	defer raw.Close()
+
+	wrapped := pubsub.Wrap(hub, pubsub.Encryption(keyring, "secret.>"))
+	defer wrapped.Close()
+	s, err := wrapped.SubscribeContext(ctx, []string{secret, public})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	_, err = wrapped.PublishContext(ctx, []string{secret, public}, map[string]interface{}{"card": "4111"})
+	ok(t, "PublishContext", err)
+	ok(t, "Rotate", keyring.Rotate("k2", bytes.Repeat([]byte{2}, 32)))
+	_, err = wrapped.PublishContext(ctx, []string{secret}, map[string]interface{}{"card": "5500"}, pubsub.ContentType("msgpack"))
+	ok(t, "PublishContext", err)
+
+	received := make(map[string][]string)
+	for i := 0; i < 3; i++ {
+		select {
+		case env := <-raw.ReadEnvelope():
+			if env.Channel == secret {
+				data, isBytes := env.Payload.([]byte)
+				if !isBytes || bytes.Contains(data, []byte("4111")) || bytes.Contains(data, []byte("5500")) {
+					t.Errorf("expected encrypted payload, got %v", env.Payload)
+				}
+				received["raw"] = append(received["raw"], env.Header(pubsub.HeaderEncryptionKey))
+			} else if len(env.Header(pubsub.HeaderEncryptionKey)) > 0 {
+				t.Errorf("expected plain message in %s", env.Channel)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	sort.Strings(received["raw"])
+	if fmt.Sprint(received["raw"]) != "[k1 k2]" {
+		t.Errorf("expected messages encrypted with rotated keys, got %v", received["raw"])
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case env := <-s.ReadEnvelope():
+			m, isMap := env.Payload.(map[string]interface{})
+			if !isMap {
+				t.Fatalf("expected decrypted payload, got %T", env.Payload)
+			}
+			received[env.Channel] = append(received[env.Channel], fmt.Sprint(m["card"]))
+			if len(env.Header(pubsub.HeaderEncryptionKey)) > 0 {
+				t.Error("expected encryption headers to be removed")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	if fmt.Sprint(received[secret]) != "[4111 5500]" || fmt.Sprint(received[public]) != "[4111]" {
+		t.Errorf("unexpected decrypted messages %v", received)
+	}
+}
+
+// Verifies that trusted hub receives messages signed by itself only,
+// rogue hub does not know signing secret.
+func verifySigning(t *testing.T, trusted, rogue pubsub.Hub) {
+	defer trusted.Close()
+	defer rogue.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channel := "signed." + pubsub.NewID()
+	s, err := trusted.SubscribeContext(ctx, []string{channel})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	_, err = rogue.PublishContext(ctx, []string{channel}, map[string]interface{}{"from": "rogue"})
+	ok(t, "PublishContext", err)
+	_, err = trusted.PublishContext(ctx, []string{channel}, map[string]interface{}{"from": "trusted"})
+	ok(t, "PublishContext", err)
+
+	select {
+	case msg := <-s.Read():
+		if from := msg.(map[string]interface{})["from"]; from != "trusted" {
+			t.Errorf("expected only trusted message, got %v", from)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case msg := <-s.Read():
+		t.Errorf("unexpected message %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Verifies that payloads of any JSON type and raw bytes are delivered unchanged.
+func verifyPayloads(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channel := "payloads." + pubsub.NewID()
+	s, err := hub.SubscribeContext(ctx, []string{channel})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	payloads := []interface{}{
+		"test",
+		42.5,
+		true,
+		nil,
+		[]interface{}{"a", 1.0},
+		map[string]interface{}{"n": "a"},
+		[]byte{0xff, 0, 'x'},
+		[]byte(`{"n":"a"}`),
+	}
+	for _, p := range payloads {
+		_, err := hub.PublishContext(ctx, []string{channel}, p)
+		ok(t, "PublishContext", err)
+	}
+	for _, want := range payloads {
+		select {
+		case env := <-s.ReadEnvelope():
+			if !reflect.DeepEqual(env.Payload, want) {
+				t.Errorf("expected %T %v, got %T %v", want, want, env.Payload, env.Payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+type orderCreated struct {
+	ID    string   `json:"id"`
+	Total float64  `json:"total"`
+	Items []string `json:"items"`
+}
+
+type orderShipped struct {
+	ID string `json:"id"`
+}
+
+func init() {
+	pubsub.RegisterType("order.created", orderCreated{})
+	pubsub.RegisterType("order.shipped", &orderShipped{})
+}
+
+// Verifies that payloads of registered types are received as the same Go types.
+func verifyTypes(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channel := "orders." + pubsub.NewID()
+	s, err := hub.SubscribeContext(ctx, []string{channel})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	created := orderCreated{ID: "1", Total: 9.5, Items: []string{"a", "b"}}
+	_, err = hub.PublishContext(ctx, []string{channel}, created, pubsub.ContentType("msgpack"))
+	ok(t, "PublishContext", err)
+	_, err = hub.PublishContext(ctx, []string{channel}, &orderShipped{ID: "1"})
+	ok(t, "PublishContext", err)
+
+	for _, w := range []interface{}{created, &orderShipped{ID: "1"}} {
+		select {
+		case env := <-s.ReadEnvelope():
+			if !reflect.DeepEqual(env.Payload, w) {
+				t.Errorf("expected %T %v, got %T %v", w, w, env.Payload, env.Payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+// Verifies that encrypted payloads of registered types are decoded after decryption.
+func verifyTypedEncryption(t *testing.T, hub pubsub.Hub) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keyring, err := pubsub.NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	ok(t, "NewKeyring", err)
+	wrapped := pubsub.Wrap(hub, pubsub.Encryption(keyring))
+	defer wrapped.Close()
+
+	channel := "orders." + pubsub.NewID()
+	s, err := wrapped.SubscribeContext(ctx, []string{channel})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	created := orderCreated{ID: "1", Total: 9.5, Items: []string{"a"}}
+	_, err = wrapped.PublishContext(ctx, []string{channel}, created, pubsub.ContentType("cbor"))
+	ok(t, "PublishContext", err)
+	_, err = wrapped.PublishContext(ctx, []string{channel}, &orderShipped{ID: "1"})
+	ok(t, "PublishContext", err)
+
+	for _, w := range []interface{}{created, &orderShipped{ID: "1"}} {
+		select {
+		case env := <-s.ReadEnvelope():
+			if !reflect.DeepEqual(env.Payload, w) {
+				t.Errorf("expected %T %v, got %T %v", w, w, env.Payload, env.Payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+// Verifies that message signed for one channel is rejected when replayed to another,
+// publish sends raw data to the broker.
+func verifySignedReplay(t *testing.T, hub pubsub.Hub, signer *pubsub.Signer, publish func(channel string, data []byte) error) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	captured := "signed." + pubsub.NewID()
+	target := "signed." + pubsub.NewID()
+	s, err := hub.SubscribeContext(ctx, []string{target})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	signed := func(channel, from string) []byte {
+		env := pubsub.NewEnvelope(map[string]interface{}{"from": from}).ForChannel(channel)
+		data, err := pubsub.EncodeEnvelope(env)
+		ok(t, "EncodeEnvelope", err)
+		data, err = signer.Sign(data)
+		ok(t, "Sign", err)
+		return data
+	}
+	ok(t, "publish", publish(target, signed(captured, "replay")))
+	ok(t, "publish", publish(target, signed(target, "origin")))
+
+	select {
+	case msg := <-s.Read():
+		if from := msg.(map[string]interface{})["from"]; from != "origin" {
+			t.Errorf("expected only message signed for %s, got %v", target, from)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case msg := <-s.Read():
+		t.Errorf("unexpected message %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestCompression(t *testing.T) {
+	large := map[string]interface{}{"text": strings.Repeat("lorem ipsum ", 500)}
+	small := map[string]interface{}{"text": "hi"}
+
+	for _, algorithm := range []string{"gzip", "snappy"} {
+		c, err := pubsub.CompressionConfig(pubsub.HubConfig{"compression": algorithm})
+		ok(t, "CompressionConfig", err)
+
+		plain, err := pubsub.EncodeEnvelope(pubsub.NewEnvelope(large))
+		ok(t, "EncodeEnvelope", err)
+		data, err := c.EncodeEnvelope(pubsub.NewEnvelope(large))
+		ok(t, algorithm+" EncodeEnvelope", err)
+		if !bytes.HasPrefix(data, []byte("PSZ")) || len(data) >= len(plain) {
+			t.Errorf("%s: expected compressed message of %d bytes, got %d", algorithm, len(plain), len(data))
+		}
+		env, err := pubsub.DecodeEnvelope(data)
+		ok(t, algorithm+" DecodeEnvelope", err)
+		if env.Payload.(map[string]interface{})["text"] != large["text"] {
+			t.Errorf("%s: payload is corrupted", algorithm)
+		}
+
+		// messages below threshold are sent as is
+		data, err = c.EncodeEnvelope(pubsub.NewEnvelope(small))
+		ok(t, algorithm+" EncodeEnvelope", err)
+		if bytes.HasPrefix(data, []byte("PSZ")) {
+			t.Errorf("%s: expected small message to be uncompressed", algorithm)
+		}
+	}
+
+	_, err := pubsub.CompressionConfig(pubsub.HubConfig{"compression": "lz4"})
+	if err == nil {
+		t.Error("expected unknown compression error")
+	}
+}
+
+func TestCompressionLimit(t *testing.T) {
+	defer func(n int) { pubsub.MaxDecompressedSize = n }(pubsub.MaxDecompressedSize)
+
+	bomb := map[string]interface{}{"text": strings.Repeat("0", 1<<20)}
+	for _, algorithm := range []string{"gzip", "snappy"} {
+		data, err := pubsub.Compression{Algorithm: algorithm}.EncodeEnvelope(pubsub.NewEnvelope(bomb))
+		ok(t, algorithm+" EncodeEnvelope", err)
+		if len(data) > 64<<10 {
+			t.Fatalf("%s: expected highly compressed message, got %d bytes", algorithm, len(data))
+		}
+		pubsub.MaxDecompressedSize = 1 << 20
+		if _, err := pubsub.DecodeEnvelope(data); err != pubsub.ErrMessageTooLarge {
+			t.Errorf("%s: expected ErrMessageTooLarge, got %v", algorithm, err)
+		}
+		pubsub.MaxDecompressedSize = 2 << 20
+		_, err = pubsub.DecodeEnvelope(data)
+		ok(t, algorithm+" DecodeEnvelope", err)
+	}
+}
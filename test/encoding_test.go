@@ -0,0 +1,33 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestEnvelope_Encoding(t *testing.T) {
+	env := pubsub.NewEnvelope(map[string]interface{}{"a": "b"}).ForChannel("test")
+	env.SetHeader("k", "v")
+
+	data, err := pubsub.EncodeEnvelope(env)
+	ok(t, "EncodeEnvelope", err)
+
+	res, err := pubsub.DecodeEnvelope(data)
+	ok(t, "DecodeEnvelope", err)
+
+	if res.ID != env.ID || res.Channel != "test" || !res.Time.Equal(env.Time) || res.Header("k") != "v" {
+		t.Errorf("unexpected envelope: %+v", res)
+	}
+	if res.Payload.(map[string]interface{})["a"] != "b" {
+		t.Errorf("unexpected payload: %+v", res.Payload)
+	}
+}
+
+func TestEnvelope_DecodeBarePayload(t *testing.T) {
+	res, err := pubsub.DecodeEnvelope([]byte(`{"a":"b"}`))
+	ok(t, "DecodeEnvelope", err)
+	if res.Payload.(map[string]interface{})["a"] != "b" {
+		t.Errorf("unexpected payload: %+v", res.Payload)
+	}
+}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestKeyring(t *testing.T) {
+	k, err := pubsub.NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	ok(t, "NewKeyring", err)
+
+	id, sealed, err := k.Encrypt([]byte("secret"), []byte("msg1"))
+	ok(t, "Encrypt", err)
+	if id != "k1" || bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("unexpected sealed data of key %s", id)
+	}
+
+	ok(t, "Rotate", k.Rotate("k2", bytes.Repeat([]byte{2}, 16)))
+	if k.Current() != "k2" {
+		t.Errorf("expected current key k2, got %s", k.Current())
+	}
+	id2, _, err := k.Encrypt([]byte("secret"), nil)
+	ok(t, "Encrypt", err)
+	if id2 != "k2" {
+		t.Errorf("expected encryption with current key, got %s", id2)
+	}
+
+	// old key still decrypts
+	data, err := k.Decrypt(id, sealed, []byte("msg1"))
+	ok(t, "Decrypt", err)
+	if string(data) != "secret" {
+		t.Errorf("unexpected decrypted data %q", data)
+	}
+	if _, err := k.Decrypt(id, sealed, []byte("msg2")); err == nil {
+		t.Error("expected authentication error for another message")
+	}
+
+	if err := k.Remove("k2"); err == nil {
+		t.Error("expected error removing current key")
+	}
+	ok(t, "Remove", k.Remove("k1"))
+	if _, err := k.Decrypt(id, sealed, []byte("msg1")); err != pubsub.ErrUnknownKey {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+
+	if err := k.Add("bad", []byte("short")); err == nil {
+		t.Error("expected invalid key size error")
+	}
+}
+
+func TestEncryptionFilter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keyring, err := pubsub.NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	ok(t, "NewKeyring", err)
+	wrapped := pubsub.Wrap(pubsub.NewHub(), pubsub.Encryption(keyring))
+	defer wrapped.Close()
+
+	// filter is evaluated on decrypted payload
+	s, err := wrapped.SubscribeContext(ctx, []string{"orders"}, pubsub.Filter(`a == 1`))
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	for _, a := range []int{2, 1} {
+		_, err = wrapped.PublishContext(ctx, []string{"orders"}, map[string]interface{}{"a": a})
+		ok(t, "PublishContext", err)
+	}
+	select {
+	case env := <-s.ReadEnvelope():
+		if a := env.Payload.(map[string]interface{})["a"]; a != float64(1) {
+			t.Errorf("expected matching message, got a=%v", a)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	if _, err := wrapped.SubscribeContext(ctx, []string{"orders"}, pubsub.Filter(`a ==`)); err == nil {
+		t.Error("expected invalid filter to fail subscription")
+	}
+}
+
+func TestEncryptionResult(t *testing.T) {
+	keyring, err := pubsub.NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	ok(t, "NewKeyring", err)
+	wrapped := pubsub.Wrap(pubsub.NewHub(), pubsub.Encryption(keyring, "secret.>"))
+	defer wrapped.Close()
+
+	channels := []string{"secret.a", "public", "secret.b", "news"}
+	result, err := wrapped.PublishContext(context.Background(), channels, "msg")
+	ok(t, "PublishContext", err)
+	if len(result.Channels) != len(channels) {
+		t.Fatalf("expected result of every channel, got %+v", result.Channels)
+	}
+	for i, c := range result.Channels {
+		if c.Name != channels[i] {
+			t.Errorf("expected result of %s at %d, got %s", channels[i], i, c.Name)
+		}
+	}
+}
+
+func TestEncryptionBinding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keyring, err := pubsub.NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	ok(t, "NewKeyring", err)
+	hub := pubsub.NewHub()
+	defer hub.Close()
+	wrapped := pubsub.Wrap(hub, pubsub.Encryption(keyring))
+
+	raw, err := hub.SubscribeContext(ctx, []string{"a", "b"})
+	ok(t, "SubscribeContext", err)
+	defer raw.Close()
+	s, err := wrapped.SubscribeContext(ctx, []string{"b"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	captured := make(map[string]*pubsub.Envelope)
+	_, err = wrapped.PublishContext(ctx, []string{"a", "b"}, map[string]interface{}{"card": "4111"})
+	ok(t, "PublishContext", err)
+	for len(captured) < 2 {
+		select {
+		case env := <-raw.ReadEnvelope():
+			captured[env.Channel] = env
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+	}
+	select {
+	case <-s.ReadEnvelope():
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// ciphertext of channel a moved to channel b
+	_, err = hub.PublishContext(ctx, []string{"b"}, captured["a"])
+	ok(t, "PublishContext", err)
+	// codec of message changed
+	forged := pubsub.NewEnvelope(captured["b"])
+	forged.SetHeader(pubsub.HeaderEncryptedContentType, pubsub.ContentTypeMsgpack)
+	_, err = hub.PublishContext(ctx, []string{"b"}, forged)
+	ok(t, "PublishContext", err)
+
+	select {
+	case env := <-s.ReadEnvelope():
+		t.Errorf("unexpected message %v", env.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
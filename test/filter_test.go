@@ -0,0 +1,55 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestFilter(t *testing.T) {
+	event := &pubsub.Event{
+		Action:       "POST",
+		ResourceType: "task",
+		Payload: map[string]interface{}{
+			"priority": 3,
+			"tags":     []interface{}{"urgent", "backend"},
+			"user":     map[string]interface{}{"name": "bob", "admin": false},
+		},
+	}
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`resource_type == "task"`, true},
+		{`resource_type == "task" && action != "GET"`, true},
+		{`resource_type == 'task' && action == "GET"`, false},
+		{`action == "GET" || payload.priority >= 3`, true},
+		{`payload.priority > 3`, false},
+		{`payload.priority < 3.5`, true},
+		{`payload.tags[0] == "urgent"`, true},
+		{`payload.tags[5] == null`, true},
+		{`payload.user.name == "bob" && !payload.user.admin`, true},
+		{`!(resource_type == "task")`, false},
+		{`payload.missing`, false},
+		{`payload.user`, true},
+		{`resource_id == null`, true},
+	}
+	for _, tt := range tests {
+		f, err := pubsub.ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(&pubsub.Envelope{Payload: event}); got != tt.match {
+			t.Errorf("%q = %v, want %v", tt.expr, got, tt.match)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{``, `a ==`, `a = 1`, `(a == 1`, `"unterminated`, `a == 1 b`, `a[x] == 1`, `a & b`} {
+		if _, err := pubsub.ParseFilter(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestInboxDropOldest(t *testing.T) {
+	ib := pubsub.NewInbox(pubsub.MakeSubscribeOptions(pubsub.Buffer(1, pubsub.OverflowDropOldest)), nil)
+	defer ib.Close()
+
+	ib.Push(pubsub.NewEnvelope(0))
+	// let pump take the first envelope
+	time.Sleep(10 * time.Millisecond)
+	for i := 1; i < 5; i++ {
+		if !ib.Push(pubsub.NewEnvelope(i)) {
+			t.Errorf("expected envelope %d to be queued", i)
+		}
+	}
+
+	select {
+	case env := <-ib.ReadEnvelope():
+		if env.Payload != 4 {
+			t.Errorf("expected latest envelope, got %v", env.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	if n := ib.Dropped(); n != 4 {
+		t.Errorf("expected 4 dropped envelopes, got %d", n)
+	}
+}
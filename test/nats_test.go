@@ -1,9 +1,12 @@
 package test
 
 import (
+	"context"
 	"testing"
 
+	"github.com/gocontrib/pubsub"
 	"github.com/gocontrib/pubsub/nats"
+	natsio "github.com/nats-io/nats.go"
 )
 
 func TestNats_Basic(t *testing.T) {
@@ -11,3 +14,196 @@ func TestNats_Basic(t *testing.T) {
 	ok(t, "Open", err)
 	verifyBasicAPI(t, hub)
 }
+
+func TestNats_PublishContext(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyPublishContext(t, hub)
+}
+
+func TestNats_PatternSubscribe(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyPatternSubscribe(t, hub)
+}
+
+func TestNats_WildcardChannel(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	defer hub.Close()
+
+	ctx := context.Background()
+	if _, err := hub.SubscribeContext(ctx, []string{"orders.*"}); err == nil {
+		t.Error("expected wildcard channel to fail subscription")
+	}
+	s, err := hub.SubscribeContext(ctx, []string{"orders.new"})
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+	if err := s.Add("audit.>"); err == nil {
+		t.Error("expected wildcard channel to fail Add")
+	}
+}
+
+func TestNats_Envelope(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyEnvelope(t, hub)
+}
+
+func TestNats_Overflow(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyOverflow(t, hub)
+}
+
+func TestNats_AddRemove(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyAddRemove(t, hub)
+}
+
+func TestNats_Group(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyGroup(t, hub)
+}
+
+func TestNats_Request(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyRequest(t, hub)
+}
+
+func TestNats_RetainUnsupported(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	defer hub.Close()
+	_, err = hub.PublishContext(context.Background(), []string{"status"}, "on", pubsub.Retain())
+	if err != pubsub.ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestNats_TTL(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyTTL(t, hub)
+}
+
+func TestNats_Scheduler(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyScheduler(t, hub, nil)
+}
+
+func TestNats_Filter(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyFilter(t, hub)
+}
+
+func TestNats_Wrap(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyWrap(t, hub)
+}
+
+func TestNats_Inspector(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyInspector(t, hub)
+}
+
+func TestNats_Metrics(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyMetrics(t, hub, "nats")
+}
+
+func TestNats_Trace(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyTrace(t, hub)
+}
+
+func TestNats_Shutdown(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyShutdown(t, hub)
+}
+
+func TestNats_ShutdownPublish(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyShutdownPublish(t, hub)
+}
+
+func TestNats_Codecs(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyCodecs(t, hub)
+}
+
+func TestNats_Compression(t *testing.T) {
+	hub, err := pubsub.MakeHub(pubsub.HubConfig{
+		"driver":      "nats",
+		"compression": "snappy",
+	})
+	ok(t, "MakeHub", err)
+	verifyCompression(t, hub)
+}
+
+func TestNats_Encryption(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyEncryption(t, hub)
+}
+
+func TestNats_Signing(t *testing.T) {
+	trusted, err := pubsub.MakeHub(pubsub.HubConfig{
+		"driver":       "nats",
+		"signing_keys": "k1:secret",
+		"signing_key":  "k1",
+	})
+	ok(t, "MakeHub", err)
+	rogue, err := nats.Open()
+	ok(t, "Open", err)
+	verifySigning(t, trusted, rogue)
+}
+
+func TestNats_Payloads(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyPayloads(t, hub)
+}
+
+func TestNats_Types(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyTypes(t, hub)
+}
+
+func TestNats_TypedEncryption(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyTypedEncryption(t, hub)
+}
+
+func TestNats_SignedReplay(t *testing.T) {
+	signer, err := pubsub.NewSigner(map[string][]byte{"k1": []byte("secret")}, "k1", pubsub.VerifyReject, 0)
+	ok(t, "NewSigner", err)
+	hub, err := pubsub.MakeHub(pubsub.HubConfig{
+		"driver":       "nats",
+		"signing_keys": "k1:secret",
+	})
+	ok(t, "MakeHub", err)
+	conn, err := natsio.Connect(natsio.DefaultURL)
+	ok(t, "Connect", err)
+	defer conn.Close()
+	verifySignedReplay(t, hub, signer, func(channel string, data []byte) error {
+		if err := conn.Publish(channel, data); err != nil {
+			return err
+		}
+		return conn.Flush()
+	})
+}
@@ -0,0 +1,55 @@
+package test
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gocontrib/pubsub"
+	_ "github.com/gocontrib/pubsub/nsq"
+)
+
+func nsqConfig(t *testing.T) pubsub.HubConfig {
+	nsqd := os.Getenv("NSQD_ADDR")
+	if len(nsqd) == 0 {
+		nsqd = "127.0.0.1:4150"
+	}
+	lookupd := os.Getenv("NSQLOOKUPD_ADDR")
+	if len(lookupd) == 0 {
+		lookupd = "127.0.0.1:4161"
+	}
+	conn, err := net.DialTimeout("tcp", nsqd, time.Second)
+	if err != nil {
+		t.Skipf("nsqd is not reachable: %v", err)
+	}
+	conn.Close()
+	return pubsub.HubConfig{"driver": "nsq", "nsqd": nsqd, "nsqlookupd": lookupd}
+}
+
+func openNsq(t *testing.T) pubsub.Hub {
+	hub, err := pubsub.MakeHub(nsqConfig(t))
+	ok(t, "MakeHub", err)
+	return hub
+}
+
+func TestNsq_AddRemove(t *testing.T) {
+	verifyAddRemove(t, openNsq(t))
+}
+
+func TestNsq_Group(t *testing.T) {
+	verifyGroup(t, openNsq(t))
+}
+
+func TestNsq_Shutdown(t *testing.T) {
+	verifyShutdown(t, openNsq(t))
+}
+
+func TestNsq_Signing(t *testing.T) {
+	config := nsqConfig(t)
+	config["signing_keys"] = "k1:secret"
+	config["signing_key"] = "k1"
+	trusted, err := pubsub.MakeHub(config)
+	ok(t, "MakeHub", err)
+	verifySigning(t, trusted, openNsq(t))
+}
@@ -0,0 +1,44 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "orders.new", false},
+		{"orders.*", "orders.new", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.new.paid", false},
+		{"*.new", "orders.new", true},
+		{"orders.>", "orders.new", true},
+		{"orders.>", "orders.new.paid", true},
+		{"orders.>", "orders", false},
+		{">", "orders", true},
+	}
+	for _, tt := range tests {
+		if got := pubsub.MatchPattern(tt.pattern, tt.name); got != tt.match {
+			t.Errorf("MatchPattern(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, p := range []string{"a.*", "a.>", "*", ">"} {
+		if err := pubsub.ValidatePattern(p); err != nil {
+			t.Errorf("ValidatePattern(%q) failed: %v", p, err)
+		}
+	}
+	for _, p := range []string{"", "a..b", "a.>.b"} {
+		if err := pubsub.ValidatePattern(p); err == nil {
+			t.Errorf("ValidatePattern(%q) expected error", p)
+		}
+	}
+}
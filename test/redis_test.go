@@ -1,18 +1,218 @@
 package test
 
 import (
+	"context"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/gocontrib/pubsub"
 	"github.com/gocontrib/pubsub/redis"
+	"github.com/soveran/redisurl"
 )
 
+func redisURL() string {
+	if url := os.Getenv("REDIS_URL"); len(url) > 0 {
+		return url
+	}
+	return "tcp://127.0.0.1:6379/11"
+}
+
+func openRedis(t *testing.T) pubsub.Hub {
+	hub, err := redis.Open(redisURL())
+	ok(t, "Open", err)
+	return hub
+}
+
 func TestRedis_Basic(t *testing.T) {
+	verifyBasicAPI(t, openRedis(t))
+}
+
+func TestRedis_PublishContext(t *testing.T) {
+	verifyPublishContext(t, openRedis(t))
+}
+
+func TestRedis_PatternSubscribe(t *testing.T) {
+	verifyPatternSubscribe(t, openRedis(t))
+}
+
+func TestRedis_Envelope(t *testing.T) {
+	verifyEnvelope(t, openRedis(t))
+}
+
+func TestRedis_Overflow(t *testing.T) {
+	verifyOverflow(t, openRedis(t))
+}
+
+func TestRedis_AddRemove(t *testing.T) {
+	verifyAddRemove(t, openRedis(t))
+}
+
+func TestRedis_GroupUnsupported(t *testing.T) {
+	hub := openRedis(t)
+	defer hub.Close()
+	_, err := hub.SubscribeContext(context.Background(), []string{"jobs"}, pubsub.Group("workers"))
+	if err != pubsub.ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestRedis_Request(t *testing.T) {
+	verifyRequest(t, openRedis(t))
+}
+
+func TestRedis_Retained(t *testing.T) {
+	verifyRetained(t, openRedis(t))
+}
+
+func TestRedis_TTL(t *testing.T) {
+	verifyTTL(t, openRedis(t))
+}
+
+func TestRedis_Scheduler(t *testing.T) {
+	hub := openRedis(t)
+	store, err := redis.NewScheduleStore(hub, "pubsub:schedule:"+pubsub.NewID())
+	ok(t, "NewScheduleStore", err)
+	verifyScheduler(t, hub, store)
+
+	// wrapped hub made by MakeHub with codec
+	wrapped, err := pubsub.MakeHub(pubsub.HubConfig{"driver": "redis", "url": redisURL(), "codec": "cbor"})
+	ok(t, "MakeHub", err)
+	store, err = redis.NewScheduleStore(wrapped, "pubsub:schedule:"+pubsub.NewID())
+	ok(t, "NewScheduleStore", err)
+	verifyScheduler(t, wrapped, store)
+}
+
+func TestRedis_Filter(t *testing.T) {
+	verifyFilter(t, openRedis(t))
+}
+
+func TestRedis_Wrap(t *testing.T) {
+	verifyWrap(t, openRedis(t))
+}
+
+func TestRedis_Inspector(t *testing.T) {
+	verifyInspector(t, openRedis(t))
+}
+
+func TestRedis_Presence(t *testing.T) {
+	verifyPresence(t, openRedis(t))
+}
+
+func TestRedis_PresenceHeartbeat(t *testing.T) {
+	heartbeat, timeout := redis.PresenceHeartbeat, redis.PresenceTimeout
+	redis.PresenceHeartbeat, redis.PresenceTimeout = 50*time.Millisecond, 200*time.Millisecond
+	defer func() {
+		redis.PresenceHeartbeat, redis.PresenceTimeout = heartbeat, timeout
+	}()
+
+	hub := openRedis(t)
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	room := "room." + pubsub.NewID()
+	s, err := hub.SubscribeContext(ctx, []string{room}, pubsub.Identity("alice", nil))
+	ok(t, "SubscribeContext", err)
+	defer s.Close()
+
+	// session outlives timeout while node is alive
+	time.Sleep(500 * time.Millisecond)
+	list, err := hub.(pubsub.PresenceReader).Presence(ctx, room)
+	ok(t, "Presence", err)
+	if len(list) != 1 || list[0].ID != "alice" {
+		t.Errorf("expected alice to be present, got %+v", list)
+	}
+}
+
+func TestRedis_Metrics(t *testing.T) {
+	verifyMetrics(t, openRedis(t), "redis")
+}
+
+func TestRedis_Trace(t *testing.T) {
+	verifyTrace(t, openRedis(t))
+}
+
+func TestRedis_Shutdown(t *testing.T) {
+	verifyShutdown(t, openRedis(t))
+}
+
+func TestRedis_ShutdownPublish(t *testing.T) {
+	verifyShutdownPublish(t, openRedis(t))
+}
+
+func TestRedis_Codecs(t *testing.T) {
+	verifyCodecs(t, openRedis(t))
+}
+
+func TestRedis_Compression(t *testing.T) {
 	url := os.Getenv("REDIS_URL")
 	if len(url) == 0 {
 		url = "tcp://127.0.0.1:6379/11"
 	}
-	hub, err := redis.Open(url)
-	ok(t, "Open", err)
-	verifyBasicAPI(t, hub)
+	hub, err := pubsub.MakeHub(pubsub.HubConfig{
+		"driver":                "redis",
+		"url":                   url,
+		"compression":           "gzip",
+		"compression_threshold": 512,
+	})
+	ok(t, "MakeHub", err)
+	verifyCompression(t, hub)
+}
+
+func TestRedis_Encryption(t *testing.T) {
+	verifyEncryption(t, openRedis(t))
+}
+
+func TestRedis_Signing(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if len(url) == 0 {
+		url = "tcp://127.0.0.1:6379/11"
+	}
+	open := func(keys, key string) pubsub.Hub {
+		hub, err := pubsub.MakeHub(pubsub.HubConfig{
+			"driver":       "redis",
+			"url":          url,
+			"signing_keys": keys,
+			"signing_key":  key,
+		})
+		ok(t, "MakeHub", err)
+		return hub
+	}
+	verifySigning(t, open("k1:secret", "k1"), open("k1:guess", "k1"))
+}
+
+func TestRedis_Payloads(t *testing.T) {
+	verifyPayloads(t, openRedis(t))
+}
+
+func TestRedis_Types(t *testing.T) {
+	verifyTypes(t, openRedis(t))
+}
+
+func TestRedis_TypedEncryption(t *testing.T) {
+	verifyTypedEncryption(t, openRedis(t))
+}
+
+func TestRedis_SignedReplay(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if len(url) == 0 {
+		url = "tcp://127.0.0.1:6379/11"
+	}
+	signer, err := pubsub.NewSigner(map[string][]byte{"k1": []byte("secret")}, "k1", pubsub.VerifyReject, 0)
+	ok(t, "NewSigner", err)
+	hub, err := pubsub.MakeHub(pubsub.HubConfig{
+		"driver":       "redis",
+		"url":          url,
+		"signing_keys": "k1:secret",
+	})
+	ok(t, "MakeHub", err)
+	conn, err := redisurl.ConnectToURL(url)
+	ok(t, "ConnectToURL", err)
+	defer conn.Close()
+	verifySignedReplay(t, hub, signer, func(channel string, data []byte) error {
+		_, err := conn.Do("PUBLISH", channel, data)
+		return err
+	})
 }
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestSigner(t *testing.T) {
+	keys := map[string][]byte{"k1": []byte("secret1"), "k2": []byte("secret2")}
+	signer, err := pubsub.NewSigner(keys, "k2", pubsub.VerifyReject, time.Minute)
+	ok(t, "NewSigner", err)
+
+	msg, err := pubsub.EncodeEnvelope(pubsub.NewEnvelope(map[string]interface{}{"v": 1}))
+	ok(t, "EncodeEnvelope", err)
+	data, err := signer.Sign(msg)
+	ok(t, "Sign", err)
+
+	v := signer.NewVerifier()
+	out, err := v.Verify(data)
+	ok(t, "Verify", err)
+	if !bytes.Equal(out, msg) {
+		t.Error("expected message without signature")
+	}
+	if _, err := v.Verify(data); err != pubsub.ErrReplayed {
+		t.Errorf("expected ErrReplayed, got %v", err)
+	}
+	// stored messages are verified without replay protection
+	_, err = signer.Verify(data)
+	ok(t, "Verify", err)
+
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-2] ^= 1
+	if _, err := v.Verify(tampered); err != pubsub.ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature, got %v", err)
+	}
+	if _, err := v.Verify(msg); err != pubsub.ErrUnsigned {
+		t.Errorf("expected ErrUnsigned, got %v", err)
+	}
+
+	// verify only signer knows old key
+	other, err := pubsub.NewSigner(map[string][]byte{"k1": []byte("secret1")}, "", pubsub.VerifyReject, 0)
+	ok(t, "NewSigner", err)
+	if _, err := other.NewVerifier().Verify(data); err != pubsub.ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for unknown key, got %v", err)
+	}
+
+	// log mode delivers anyway
+	lax, err := pubsub.NewSigner(keys, "", pubsub.VerifyLog, 0)
+	ok(t, "NewSigner", err)
+	out, err = lax.NewVerifier().Verify(msg)
+	ok(t, "Verify", err)
+	if !bytes.Equal(out, msg) {
+		t.Error("expected unsigned message to be delivered in log mode")
+	}
+
+	// signature is stripped by receivers not verifying messages
+	env, err := pubsub.DecodeEnvelope(data)
+	ok(t, "DecodeEnvelope", err)
+	if env.Payload.(map[string]interface{})["v"] != float64(1) {
+		t.Errorf("unexpected payload %v", env.Payload)
+	}
+}
+
+func TestSignerWindow(t *testing.T) {
+	signer, err := pubsub.NewSigner(map[string][]byte{"k1": []byte("secret")}, "k1", pubsub.VerifyReject, 20*time.Millisecond)
+	ok(t, "NewSigner", err)
+	data, err := signer.Sign([]byte("{}"))
+	ok(t, "Sign", err)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := signer.NewVerifier().Verify(data); err != pubsub.ErrReplayed {
+		t.Errorf("expected ErrReplayed for old message, got %v", err)
+	}
+}
+
+func TestSignerConfig(t *testing.T) {
+	signer, err := pubsub.SignerConfig(pubsub.HubConfig{})
+	ok(t, "SignerConfig", err)
+	if signer != nil {
+		t.Error("expected no signer")
+	}
+	_, err = pubsub.SignerConfig(pubsub.HubConfig{"signing_keys": "k1:secret", "signing_key": "k2"})
+	if err == nil {
+		t.Error("expected unknown signing key error")
+	}
+	_, err = pubsub.SignerConfig(pubsub.HubConfig{"signing_keys": "k1:secret", "signing_mode": "ignore"})
+	if err == nil {
+		t.Error("expected unknown mode error")
+	}
+	_, err = pubsub.SignerConfig(pubsub.HubConfig{"signing_keys": "k1:a,k2:b", "signing_key": "k2", "signing_window": "1m"})
+	ok(t, "SignerConfig", err)
+}
@@ -0,0 +1,86 @@
+//go:build go1.18
+
+package test
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gocontrib/pubsub"
+	"github.com/gocontrib/pubsub/nats"
+	"github.com/gocontrib/pubsub/wal"
+)
+
+// Verifies that topic delivers messages decoded into its type.
+func verifyTopic(t *testing.T, hub pubsub.Hub) {
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	topic := pubsub.NewTopic[orderCreated](hub, "orders."+pubsub.NewID())
+	s, err := topic.Subscribe(ctx)
+	ok(t, "Subscribe", err)
+
+	created := orderCreated{ID: "1", Total: 9.5, Items: []string{"a"}}
+	_, err = topic.Publish(ctx, created)
+	ok(t, "Publish", err)
+	// untyped message of the same shape
+	_, err = hub.PublishContext(ctx, []string{topic.Channel}, map[string]interface{}{"id": "2"})
+	ok(t, "PublishContext", err)
+
+	for _, want := range []orderCreated{created, {ID: "2"}} {
+		select {
+		case v := <-s.Read():
+			if !reflect.DeepEqual(v, want) {
+				t.Errorf("expected %+v, got %+v", want, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	ok(t, "Close", s.Close())
+	select {
+	case _, open := <-s.Read():
+		if open {
+			t.Error("expected closed topic subscription")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestAs(t *testing.T) {
+	v, err := pubsub.As[orderShipped](&orderShipped{ID: "1"})
+	ok(t, "As", err)
+	if v.ID != "1" {
+		t.Errorf("unexpected value %+v", v)
+	}
+	if _, err := pubsub.As[orderShipped]([]byte("x")); err == nil {
+		t.Error("expected error converting raw bytes")
+	}
+}
+
+func TestHub_Topic(t *testing.T) {
+	verifyTopic(t, pubsub.NewHub())
+}
+
+func TestRedis_Topic(t *testing.T) {
+	verifyTopic(t, openRedis(t))
+}
+
+func TestNats_Topic(t *testing.T) {
+	hub, err := nats.Open()
+	ok(t, "Open", err)
+	verifyTopic(t, hub)
+}
+
+func TestWal_Topic(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyTopic(t, openWal(t, wal.Config{Dir: dir}))
+}
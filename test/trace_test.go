@@ -0,0 +1,35 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestTraceParent(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		sc, err := pubsub.ParseTraceParent(test.value)
+		if test.valid != (err == nil) {
+			t.Errorf("%q: expected valid=%v, got %v", test.value, test.valid, err)
+			continue
+		}
+		if test.valid && test.value[:2] == "00" && sc.TraceParent() != test.value {
+			t.Errorf("%q: formatted as %q", test.value, sc.TraceParent())
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gocontrib/pubsub"
+)
+
+func TestTypes(t *testing.T) {
+	if name := pubsub.TypeName(orderCreated{}); name != "order.created" {
+		t.Errorf("unexpected type name %q", name)
+	}
+	if name := pubsub.TypeName(&orderCreated{}); name != "" {
+		t.Errorf("expected pointer type to be unregistered, got %q", name)
+	}
+
+	// msgpack matches struct fields by msgpack tags and names only
+	for _, ct := range []string{"json", "cbor"} {
+		env := pubsub.NewEnvelope(map[string]interface{}{"id": "2"}, pubsub.ContentType(ct), pubsub.MessageType("order.shipped"))
+		data, err := pubsub.EncodeEnvelope(env)
+		ok(t, "EncodeEnvelope", err)
+		out, err := pubsub.DecodeEnvelope(data)
+		ok(t, "DecodeEnvelope", err)
+		if !reflect.DeepEqual(out.Payload, &orderShipped{ID: "2"}) {
+			t.Errorf("%s: expected *orderShipped, got %T %v", ct, out.Payload, out.Payload)
+		}
+	}
+
+	// unknown types are decoded as generic values
+	env := pubsub.NewEnvelope(map[string]interface{}{"id": "3"}, pubsub.MessageType("order.unknown"))
+	data, err := pubsub.EncodeEnvelope(env)
+	ok(t, "EncodeEnvelope", err)
+	out, err := pubsub.DecodeEnvelope(data)
+	ok(t, "DecodeEnvelope", err)
+	if _, isMap := out.Payload.(map[string]interface{}); !isMap {
+		t.Errorf("expected generic payload, got %T", out.Payload)
+	}
+}
@@ -0,0 +1,353 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gocontrib/pubsub"
+	"github.com/gocontrib/pubsub/wal"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "pubsub-wal")
+	ok(t, "TempDir", err)
+	return dir
+}
+
+func openWal(t *testing.T, config wal.Config) pubsub.Hub {
+	hub, err := wal.Open(config)
+	ok(t, "Open", err)
+	return hub
+}
+
+func TestWal_Basic(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyBasicAPI(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_PublishContext(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyPublishContext(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_Envelope(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyEnvelope(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_History(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyHistory(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_Restart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	config := wal.Config{Dir: dir, History: 3, Fsync: wal.FsyncAlways, SegmentSize: 256}
+
+	hub := openWal(t, config)
+	for i := 0; i < 5; i++ {
+		_, err := hub.PublishContext(ctx, []string{"news"}, map[string]interface{}{"i": i})
+		ok(t, "PublishContext", err)
+	}
+	hub.Close()
+
+	// simulate torn write
+	files, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	ok(t, "Glob", err)
+	f, err := os.OpenFile(files[len(files)-1], os.O_APPEND|os.O_WRONLY, 0644)
+	ok(t, "OpenFile", err)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	// restart twice to verify compacted log
+	for k := 0; k < 2; k++ {
+		hub = openWal(t, config)
+
+		list, err := hub.(pubsub.HistoryReader).History(ctx, "news", 10)
+		ok(t, "History", err)
+		if len(list) != 3 || list[0].Payload.(map[string]interface{})["i"] != float64(2) {
+			t.Fatalf("unexpected history after restart: %+v", list)
+		}
+
+		s, err := hub.SubscribeContext(ctx, []string{"news"}, pubsub.ReplaySince(list[1].ID))
+		ok(t, "SubscribeContext", err)
+		env := <-s.ReadEnvelope()
+		if env.ID != list[2].ID {
+			t.Errorf("expected replay of last message, got %+v", env)
+		}
+		s.Close()
+		hub.Close()
+	}
+}
+
+func TestWal_OpenCompaction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	config := wal.Config{Dir: dir, History: 2, Fsync: wal.FsyncAlways}
+	segments := func() string {
+		files, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+		ok(t, "Glob", err)
+		for i, f := range files {
+			files[i] = filepath.Base(f)
+		}
+		return strings.Join(files, ",")
+	}
+	publish := func(n int) {
+		hub := openWal(t, config)
+		for i := 0; i < n; i++ {
+			_, err := hub.PublishContext(ctx, []string{"news"}, map[string]interface{}{"i": i})
+			ok(t, "PublishContext", err)
+		}
+		hub.Close()
+	}
+
+	publish(2)
+	before := segments()
+	publish(0)
+	if after := segments(); after != before {
+		t.Errorf("expected log to be kept as is, got segments %s instead of %s", after, before)
+	}
+
+	// records out of history are dropped on start
+	publish(1)
+	before = segments()
+	publish(0)
+	if after := segments(); after == before {
+		t.Errorf("expected log to be compacted, got segments %s", after)
+	}
+}
+
+func TestWal_TransientChannels(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	config := wal.Config{Dir: dir, Fsync: wal.FsyncAlways}
+	channels := []string{"news", "_reply.1", pubsub.PresenceChannel("news")}
+
+	hub := openWal(t, config)
+	_, err := hub.PublishContext(ctx, channels, map[string]interface{}{"i": 1})
+	ok(t, "PublishContext", err)
+	hub.Close()
+
+	hub = openWal(t, config)
+	defer hub.Close()
+	for i, name := range channels {
+		list, err := hub.(pubsub.HistoryReader).History(ctx, name, 10)
+		ok(t, "History", err)
+		if want := map[bool]int{true: 1, false: 0}[i == 0]; len(list) != want {
+			t.Errorf("expected %d messages of %s, got %d", want, name, len(list))
+		}
+	}
+}
+
+func TestWal_CorruptedLength(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	config := wal.Config{Dir: dir, Fsync: wal.FsyncAlways}
+
+	hub := openWal(t, config)
+	_, err := hub.PublishContext(ctx, []string{"news"}, map[string]interface{}{"i": 1})
+	ok(t, "PublishContext", err)
+	hub.Close()
+
+	// record header claiming 4GB of data
+	files, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	ok(t, "Glob", err)
+	f, err := os.OpenFile(files[len(files)-1], os.O_APPEND|os.O_WRONLY, 0644)
+	ok(t, "OpenFile", err)
+	f.Write([]byte{0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0, 1, 2, 3})
+	f.Close()
+
+	hub = openWal(t, config)
+	defer hub.Close()
+	list, err := hub.(pubsub.HistoryReader).History(ctx, "news", 10)
+	ok(t, "History", err)
+	if len(list) != 1 {
+		t.Errorf("expected record before corrupted tail, got %+v", list)
+	}
+}
+
+func TestWal_Retained(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyRetained(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_RetainedRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	config := wal.Config{Dir: dir, History: 2, Fsync: wal.FsyncAlways}
+	retained := func(hub pubsub.Hub, channel string) interface{} {
+		env, err := hub.(pubsub.Retainer).Retained(ctx, channel)
+		ok(t, "Retained", err)
+		if env == nil {
+			return nil
+		}
+		return env.Payload.(map[string]interface{})["v"]
+	}
+
+	hub := openWal(t, config)
+	for _, channel := range []string{"status", "device"} {
+		_, err := hub.PublishContext(ctx, []string{channel}, map[string]interface{}{"v": "retained"}, pubsub.Retain())
+		ok(t, "PublishContext", err)
+	}
+	// retained message is evicted from history
+	for i := 0; i < 3; i++ {
+		_, err := hub.PublishContext(ctx, []string{"status"}, map[string]interface{}{"v": i})
+		ok(t, "PublishContext", err)
+	}
+	ok(t, "ClearRetained", hub.(pubsub.Retainer).ClearRetained(ctx, "device"))
+	hub.Close()
+
+	for k := 0; k < 2; k++ {
+		hub = openWal(t, config)
+		if v := retained(hub, "status"); v != "retained" {
+			t.Errorf("restart %d: expected retained message, got %v", k, v)
+		}
+		if v := retained(hub, "device"); v != nil {
+			t.Errorf("restart %d: expected cleared retained message, got %v", k, v)
+		}
+		list, err := hub.(pubsub.HistoryReader).History(ctx, "status", 10)
+		ok(t, "History", err)
+		if len(list) != 2 || list[1].Payload.(map[string]interface{})["v"] != float64(2) {
+			t.Errorf("restart %d: unexpected history %+v", k, list)
+		}
+		// compacted log keeps retained message
+		ok(t, "Compact", hub.(interface{ Compact() error }).Compact())
+		hub.Close()
+	}
+}
+
+func TestWal_Trace(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyTrace(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_Shutdown(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyShutdown(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_ShutdownPublish(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyShutdownPublish(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_Codecs(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyCodecs(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_Compression(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	config := wal.Config{Dir: dir, Compression: pubsub.Compression{Algorithm: "gzip"}}
+	text := strings.Repeat("snapshot ", 1000)
+
+	hub := openWal(t, config)
+	_, err := hub.PublishContext(ctx, []string{"news"}, map[string]interface{}{"text": text})
+	ok(t, "PublishContext", err)
+	hub.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	ok(t, "Glob", err)
+	var size int64
+	for _, name := range files {
+		if fi, err := os.Stat(name); err == nil {
+			size += fi.Size()
+		}
+	}
+	if size >= int64(len(text)) {
+		t.Errorf("expected compressed log, got %d bytes", size)
+	}
+
+	// compressed records are restored
+	hub = openWal(t, config)
+	defer hub.Close()
+	list, err := hub.(pubsub.HistoryReader).History(ctx, "news", 10)
+	ok(t, "History", err)
+	if len(list) != 1 || list[0].Payload.(map[string]interface{})["text"] != text {
+		t.Fatalf("unexpected history after restart: %d messages", len(list))
+	}
+
+	dir2 := tempDir(t)
+	defer os.RemoveAll(dir2)
+	verifyCompression(t, openWal(t, wal.Config{Dir: dir2, Compression: config.Compression}))
+}
+
+func TestWal_Encryption(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyEncryption(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_Signing(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	signer, err := pubsub.NewSigner(map[string][]byte{"k1": []byte("secret")}, "k1", pubsub.VerifyReject, 0)
+	ok(t, "NewSigner", err)
+
+	// unsigned record written by another process
+	hub := openWal(t, wal.Config{Dir: dir, Fsync: wal.FsyncAlways})
+	_, err = hub.PublishContext(ctx, []string{"news"}, map[string]interface{}{"signed": false})
+	ok(t, "PublishContext", err)
+	hub.Close()
+
+	hub = openWal(t, wal.Config{Dir: dir, Fsync: wal.FsyncAlways, Signer: signer})
+	_, err = hub.PublishContext(ctx, []string{"news"}, map[string]interface{}{"signed": true})
+	ok(t, "PublishContext", err)
+	hub.Close()
+
+	// signed records outlive signature window on restore
+	hub = openWal(t, wal.Config{Dir: dir, Signer: signer})
+	defer hub.Close()
+	list, err := hub.(pubsub.HistoryReader).History(ctx, "news", 10)
+	ok(t, "History", err)
+	if len(list) != 1 || list[0].Payload.(map[string]interface{})["signed"] != true {
+		t.Errorf("expected only signed record, got %+v", list)
+	}
+}
+
+func TestWal_Payloads(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyPayloads(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_Types(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyTypes(t, openWal(t, wal.Config{Dir: dir}))
+}
+
+func TestWal_TypedEncryption(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	verifyTypedEncryption(t, openWal(t, wal.Config{Dir: dir}))
+}
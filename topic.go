@@ -0,0 +1,107 @@
+//go:build go1.18
+
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Topic publishes and receives messages of type T on single channel.
+type Topic[T any] struct {
+	Hub     Hub
+	Channel string
+}
+
+// NewTopic creates typed topic of given channel,
+// T should be registered with RegisterType to be decoded by drivers directly.
+func NewTopic[T any](hub Hub, channel string) *Topic[T] {
+	return &Topic[T]{Hub: hub, Channel: channel}
+}
+
+// Publish sends message to the topic.
+func (t *Topic[T]) Publish(ctx context.Context, msg T, opts ...PublishOption) (PublishResult, error) {
+	return t.Hub.PublishContext(ctx, []string{t.Channel}, msg, opts...)
+}
+
+// Subscribe opens subscription receiving messages of the topic decoded into T,
+// messages which could not be decoded are dropped.
+func (t *Topic[T]) Subscribe(ctx context.Context, opts ...SubscribeOption) (*TopicSubscription[T], error) {
+	ch, err := t.Hub.SubscribeContext(ctx, []string{t.Channel}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s := &TopicSubscription[T]{
+		ch:  ch,
+		out: make(chan T),
+	}
+	go s.run()
+	return s, nil
+}
+
+// TopicSubscription receives typed messages of topic.
+type TopicSubscription[T any] struct {
+	ch  Channel
+	out chan T
+}
+
+// Read returns channel to receive messages, it is closed when subscription is closed.
+func (s *TopicSubscription[T]) Read() <-chan T {
+	return s.out
+}
+
+// Close stops the subscription.
+func (s *TopicSubscription[T]) Close() error {
+	return s.ch.Close()
+}
+
+func (s *TopicSubscription[T]) run() {
+	defer close(s.out)
+	for {
+		select {
+		case env, ok := <-s.ch.ReadEnvelope():
+			if !ok {
+				return
+			}
+			v, err := As[T](env.Payload)
+			if err != nil {
+				droppedMessages.WithLabelValues("type_mismatch").Inc()
+				log.Errorf("pubsub: cannot decode message %s of %s: %+v", env.ID, env.Channel, err)
+				continue
+			}
+			select {
+			case s.out <- v:
+			case <-s.ch.CloseNotify():
+				return
+			}
+		case <-s.ch.CloseNotify():
+			return
+		}
+	}
+}
+
+// As converts payload to T, generic values like maps decoded from messages of unregistered type
+// are converted via JSON.
+func As[T any](payload interface{}) (T, error) {
+	var v T
+	switch p := payload.(type) {
+	case T:
+		return p, nil
+	case *T:
+		if p != nil {
+			return *p, nil
+		}
+		return v, nil
+	case []byte:
+		return v, fmt.Errorf("pubsub: cannot convert raw bytes to %T", v)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
@@ -0,0 +1,245 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace context headers, see https://www.w3.org/TR/trace-context/.
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+var errBadTraceParent = errors.New("pubsub: malformed traceparent")
+
+// SpanContext identifies span propagated with messages.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	State   string // vendor specific tracestate
+}
+
+// IsValid reports whether trace and span ids are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Sampled reports whether trace is sampled by caller.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&1 == 1
+}
+
+// TraceParent formats span context as traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceParent parses traceparent header value.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, errBadTraceParent
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, errBadTraceParent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, errBadTraceParent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, errBadTraceParent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, errBadTraceParent
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return sc, errBadTraceParent
+	}
+	return sc, nil
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns context carrying given span context.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanFromContext returns span context carried by given context.
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// InjectTrace sets trace headers of envelope from span of given context
+// unless envelope has them already. Drivers call it on publish.
+func InjectTrace(ctx context.Context, env *Envelope) {
+	if len(env.Header(HeaderTraceParent)) > 0 {
+		return
+	}
+	if sc, ok := SpanFromContext(ctx); ok {
+		setTraceHeaders(env, sc)
+	}
+}
+
+// ExtractTrace returns context carrying span of received envelope.
+func ExtractTrace(ctx context.Context, env *Envelope) context.Context {
+	sc, ok := envelopeSpan(env)
+	if !ok {
+		return ctx
+	}
+	return ContextWithSpan(ctx, sc)
+}
+
+func envelopeSpan(env *Envelope) (SpanContext, bool) {
+	sc, err := ParseTraceParent(env.Header(HeaderTraceParent))
+	if err != nil {
+		return sc, false
+	}
+	sc.State = env.Header(HeaderTraceState)
+	return sc, true
+}
+
+func setTraceHeaders(env *Envelope, sc SpanContext) {
+	env.SetHeader(HeaderTraceParent, sc.TraceParent())
+	if len(sc.State) > 0 {
+		env.SetHeader(HeaderTraceState, sc.State)
+	} else if env.Headers != nil {
+		delete(env.Headers, HeaderTraceState)
+	}
+}
+
+// SpanKind is role of span in messaging.
+type SpanKind int
+
+// Span kinds.
+const (
+	SpanKindProducer SpanKind = iota + 1
+	SpanKindConsumer
+)
+
+// Tracer creates spans of published and received messages,
+// OpenTelemetry tracer could be adapted to it.
+type Tracer interface {
+	// Start starts span as child of given parent, parent is invalid for root span.
+	Start(ctx context.Context, name string, kind SpanKind, parent SpanContext) Span
+}
+
+// Span is started operation.
+type Span interface {
+	// SpanContext returns identity of span propagated with message.
+	SpanContext() SpanContext
+	// End finishes span, err is set if operation failed.
+	End(err error)
+}
+
+// Tracing returns middleware creating producer span for every publish and consumer span
+// for every delivery, spans are linked by trace headers of messages.
+func Tracing(tracer Tracer) Middleware {
+	return Middleware{
+		Publish: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, channels []string, env *Envelope, opts ...PublishOption) (PublishResult, error) {
+				parent, _ := SpanFromContext(ctx)
+				span := tracer.Start(ctx, strings.Join(channels, ",")+" publish", SpanKindProducer, parent)
+				sc := span.SpanContext()
+				setTraceHeaders(env, sc)
+				result, err := next(ContextWithSpan(ctx, sc), channels, env, opts...)
+				span.End(err)
+				return result, err
+			}
+		},
+		Deliver: func(next DeliverFunc) DeliverFunc {
+			return func(env *Envelope) {
+				parent, _ := envelopeSpan(env)
+				span := tracer.Start(context.Background(), env.Channel+" receive", SpanKindConsumer, parent)
+				// receiver continues trace from consumer span
+				setTraceHeaders(env, span.SpanContext())
+				next(env)
+				span.End(nil)
+			}
+		},
+	}
+}
+
+// RecordedSpan is span finished by SpanRecorder.
+type RecordedSpan struct {
+	Name        string
+	Kind        SpanKind
+	Parent      SpanContext
+	SpanContext SpanContext
+	Start       time.Time
+	End         time.Time
+	Err         error
+}
+
+// SpanRecorder is in-memory tracer keeping finished spans, useful in tests.
+type SpanRecorder struct {
+	mutex sync.Mutex
+	spans []RecordedSpan
+}
+
+// Start starts recorded span.
+func (r *SpanRecorder) Start(ctx context.Context, name string, kind SpanKind, parent SpanContext) Span {
+	sc := SpanContext{Flags: 1}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.State = parent.State
+	} else {
+		randomBytes(sc.TraceID[:])
+	}
+	randomBytes(sc.SpanID[:])
+	return &recordedSpan{
+		recorder: r,
+		span: RecordedSpan{
+			Name:        name,
+			Kind:        kind,
+			Parent:      parent,
+			SpanContext: sc,
+			Start:       time.Now(),
+		},
+	}
+}
+
+// Spans returns finished spans in order of finishing.
+func (r *SpanRecorder) Spans() []RecordedSpan {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]RecordedSpan{}, r.spans...)
+}
+
+type recordedSpan struct {
+	recorder *SpanRecorder
+	span     RecordedSpan
+	once     sync.Once
+}
+
+func (s *recordedSpan) SpanContext() SpanContext {
+	return s.span.SpanContext
+}
+
+func (s *recordedSpan) End(err error) {
+	s.once.Do(func() {
+		s.span.End = time.Now()
+		s.span.Err = err
+		s.recorder.mutex.Lock()
+		s.recorder.spans = append(s.recorder.spans, s.span)
+		s.recorder.mutex.Unlock()
+	})
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
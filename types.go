@@ -0,0 +1,76 @@
+package pubsub
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// HeaderMessageType names registered Go type of message payload, see RegisterType.
+const HeaderMessageType = "message-type"
+
+var (
+	typesMutex  sync.RWMutex
+	typesByName = make(map[string]reflect.Type)
+	typeNames   = make(map[reflect.Type]string)
+)
+
+// RegisterType registers Go type of given sample value under message type name,
+// e.g. RegisterType("order.created", OrderCreated{}).
+// Published payloads of registered type are marked with message-type header,
+// receivers decode such payloads into the same type, pointer or value like the sample.
+func RegisterType(name string, sample interface{}) {
+	if len(name) == 0 {
+		panic("pubsub: empty message type name")
+	}
+	t := reflect.TypeOf(sample)
+	if t == nil {
+		panic("pubsub: nil message type sample")
+	}
+	typesMutex.Lock()
+	defer typesMutex.Unlock()
+	if prev, ok := typesByName[name]; ok && prev != t {
+		panic(fmt.Sprintf("pubsub: message type %s registered with %v already", name, prev))
+	}
+	typesByName[name] = t
+	typeNames[t] = name
+}
+
+// TypeName returns message type name of given payload, empty if its type is not registered.
+func TypeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return ""
+	}
+	typesMutex.RLock()
+	defer typesMutex.RUnlock()
+	return typeNames[t]
+}
+
+// Returns registered type by name, nil if it is unknown.
+func typeByName(name string) reflect.Type {
+	typesMutex.RLock()
+	defer typesMutex.RUnlock()
+	return typesByName[name]
+}
+
+// Decodes payload into registered type of message, generic value if the type is unknown.
+// Encrypted payloads are decoded into registered type after decryption.
+func decodePayload(env *Envelope, codec Codec, data []byte) (interface{}, error) {
+	t := typeByName(env.Header(HeaderMessageType))
+	if t == nil || len(env.Header(HeaderEncryptionKey)) > 0 {
+		return codec.Decode(data)
+	}
+	elem := t
+	if t.Kind() == reflect.Ptr {
+		elem = t.Elem()
+	}
+	ptr := reflect.New(elem)
+	if err := codec.Unmarshal(data, ptr.Interface()); err != nil {
+		return nil, err
+	}
+	if t.Kind() == reflect.Ptr {
+		return ptr.Interface(), nil
+	}
+	return ptr.Elem().Interface(), nil
+}
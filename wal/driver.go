@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gocontrib/pubsub"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	pubsub.RegisterDriver(&driver{}, "wal", "file")
+}
+
+// FsyncPolicy defines when appended messages are flushed to disk.
+type FsyncPolicy string
+
+// Supported fsync policies.
+const (
+	FsyncAlways   FsyncPolicy = "always"   // after every publish
+	FsyncInterval FsyncPolicy = "interval" // periodically, see Config.FsyncInterval
+	FsyncNever    FsyncPolicy = "never"    // left to operating system
+)
+
+// Config of durable hub.
+type Config struct {
+	Dir             string             // directory of log segments
+	SegmentSize     int64              // size of log segment file, 64MB by default
+	History         int                // number of messages kept per channel, 1000 by default
+	Retention       time.Duration      // max age of kept messages, unlimited if zero
+	Fsync           FsyncPolicy        // FsyncInterval by default
+	FsyncInterval   time.Duration      // 1s by default
+	CompactInterval time.Duration      // 10m by default, disabled if negative
+	Compression     pubsub.Compression // compression of log records, disabled by default
+	Signer          *pubsub.Signer     // signs log records and verifies them on restore, optional
+}
+
+func (c Config) withDefaults() Config {
+	if len(c.Dir) == 0 {
+		c.Dir = "data"
+	}
+	if c.SegmentSize <= 0 {
+		c.SegmentSize = 64 << 20
+	}
+	if c.History <= 0 {
+		c.History = 1000
+	}
+	if len(c.Fsync) == 0 {
+		c.Fsync = FsyncInterval
+	}
+	if c.FsyncInterval <= 0 {
+		c.FsyncInterval = time.Second
+	}
+	if c.CompactInterval == 0 {
+		c.CompactInterval = 10 * time.Minute
+	}
+	return c
+}
+
+type driver struct{}
+
+func (d *driver) Create(config pubsub.HubConfig) (pubsub.Hub, error) {
+	log.Info("opening wal pubsub")
+	compress, err := pubsub.CompressionConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := pubsub.SignerConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return Open(Config{
+		Dir:             config.GetString("dir", ""),
+		SegmentSize:     int64(config.GetInt("segment_size", 0)),
+		History:         config.GetInt("history", 0),
+		Retention:       getDuration(config, "retention"),
+		Fsync:           FsyncPolicy(strings.ToLower(config.GetString("fsync", ""))),
+		FsyncInterval:   getDuration(config, "fsync_interval"),
+		CompactInterval: getDuration(config, "compact_interval"),
+		Compression:     compress,
+		Signer:          signer,
+	})
+}
+
+func getDuration(config pubsub.HubConfig, key string) time.Duration {
+	if d, ok := config[key].(time.Duration); ok {
+		return d
+	}
+	s := config.GetString(key, "")
+	if len(s) == 0 {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Errorf("invalid %s: %s", key, s)
+		return 0
+	}
+	return d
+}
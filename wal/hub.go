@@ -0,0 +1,470 @@
+package wal
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocontrib/pubsub"
+	log "github.com/sirupsen/logrus"
+)
+
+// Header of log records marking retained messages, "clear" value marks cleared retained message.
+const headerRetain = "wal-retain"
+
+// in-memory hub persisted to append-only log
+type hub struct {
+	// publishers hold read lock, compaction holds write lock
+	// to see every logged message in history of inner hub
+	sync.RWMutex
+	config   Config
+	inner    pubsub.Hub
+	history  pubsub.HistoryReader
+	mu       sync.Mutex // guards fields below
+	log      *wlog
+	channels map[string]struct{}
+	closed   bool
+	closing  bool           // set by Shutdown, rejects publishes
+	inflight sync.WaitGroup // pending publishes, waited by Shutdown
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Open creates in-memory hub persisted to given directory.
+func Open(config Config) (pubsub.Hub, error) {
+	config = config.withDefaults()
+
+	l, err := openLog(config.Dir, config.SegmentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	inner := pubsub.NewHub(pubsub.KeepHistory(config.History, config.Retention))
+	h := &hub{
+		config:   config,
+		inner:    inner,
+		history:  inner.(pubsub.HistoryReader),
+		log:      l,
+		channels: make(map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	skipped, err := h.restore()
+	if err != nil {
+		inner.Close()
+		return nil, err
+	}
+	// drop records restore skipped
+	if skipped > 0 {
+		if err := h.Compact(); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+
+	if config.Fsync == FsyncInterval {
+		h.every(config.FsyncInterval, h.sync)
+	}
+	if config.CompactInterval > 0 {
+		h.every(config.CompactInterval, h.Compact)
+	}
+
+	return h, nil
+}
+
+// Restores history of inner hub from log, returns number of records compaction would drop.
+func (h *hub) restore() (int, error) {
+	ctx := context.Background()
+	seen := make(map[string]struct{})
+	now := time.Now()
+	n, skipped := 0, 0
+	err := h.log.replay(func(data []byte) {
+		data, err := h.config.Signer.Verify(data)
+		if err != nil {
+			log.Errorf("wal: skip unverified record: %+v", err)
+			skipped++
+			return
+		}
+		env, err := pubsub.DecodeEnvelope(data)
+		if err != nil || len(env.Channel) == 0 {
+			log.Errorf("wal: skip bad record: %+v", err)
+			skipped++
+			return
+		}
+		if h.config.Retention > 0 && now.Sub(env.Time) > h.config.Retention {
+			skipped++
+			return
+		}
+		key := env.Channel + "\x00" + env.ID
+		if _, ok := seen[key]; ok {
+			// duplicate left by interrupted compaction
+			skipped++
+			return
+		}
+		seen[key] = struct{}{}
+		var opts []pubsub.PublishOption
+		switch env.Header(headerRetain) {
+		case "":
+		case "clear":
+			h.inner.(pubsub.Retainer).ClearRetained(ctx, env.Channel)
+			skipped++
+			return
+		default:
+			delete(env.Headers, headerRetain)
+			opts = append(opts, pubsub.Retain())
+		}
+		h.channels[env.Channel] = struct{}{}
+		h.inner.PublishContext(ctx, []string{env.Channel}, env, opts...)
+		n++
+	})
+	if err != nil {
+		return skipped, err
+	}
+	kept, err := h.kept(ctx)
+	if err != nil {
+		return skipped, err
+	}
+	// evicted from history
+	skipped += n - kept
+	log.Infof("wal: restored %d messages from %s", n, h.config.Dir)
+	return skipped, nil
+}
+
+// Returns number of messages compaction keeps, i.e. history and retained messages.
+func (h *hub) kept(ctx context.Context) (int, error) {
+	n := 0
+	for name := range h.channels {
+		msgs, err := h.history.History(ctx, name, h.config.History)
+		if err != nil {
+			return 0, err
+		}
+		n += len(msgs)
+		retained, err := h.Retained(ctx, name)
+		if err != nil {
+			return 0, err
+		}
+		if retained != nil && !containsID(msgs, retained.ID) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func containsID(list []*pubsub.Envelope, id string) bool {
+	for _, env := range list {
+		if env.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs given function periodically until hub is closed.
+func (h *hub) every(interval time.Duration, fn func() error) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := fn(); err != nil {
+					log.Errorf("wal: %+v", err)
+				}
+			case <-h.done:
+				return
+			}
+		}
+	}()
+}
+
+func (h *hub) sync() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	return h.log.sync()
+}
+
+// Compact rewrites log keeping only messages within history limits.
+func (h *hub) Compact() error {
+	h.Lock()
+	defer h.Unlock()
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return pubsub.ErrClosed
+	}
+	var names []string
+	for name := range h.channels {
+		names = append(names, name)
+	}
+	h.mu.Unlock()
+
+	ctx := context.Background()
+	var list []*pubsub.Envelope
+	for _, name := range names {
+		msgs, err := h.history.History(ctx, name, h.config.History)
+		if err != nil {
+			return err
+		}
+		retained, err := h.Retained(ctx, name)
+		if err != nil {
+			return err
+		}
+		if retained != nil {
+			retained = retained.ForChannel(name)
+			retained.SetHeader(headerRetain, "true")
+			// retained message could be out of history already
+			for i, env := range msgs {
+				if env.ID == retained.ID {
+					msgs = append(msgs[:i:i], msgs[i+1:]...)
+					break
+				}
+			}
+			msgs = append(msgs, retained)
+		}
+		list = append(list, msgs...)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+
+	var records [][]byte
+	kept := make(map[string]struct{})
+	for _, env := range list {
+		data, err := h.encode(env)
+		if err != nil {
+			return err
+		}
+		records = append(records, data)
+		kept[env.Channel] = struct{}{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return pubsub.ErrClosed
+	}
+	h.channels = kept
+	return h.log.rewrite(records)
+}
+
+func (h *hub) Publish(channels []string, msg interface{}) {
+	if len(channels) == 0 {
+		return
+	}
+	// registered before returning, so Shutdown waits for it
+	if !h.beginPublish() {
+		log.Errorf("wal publish failed: %+v", pubsub.ErrClosed)
+		return
+	}
+	go func() {
+		defer h.inflight.Done()
+		_, err := h.publishContext(context.Background(), channels, msg)
+		if err != nil {
+			log.Errorf("wal publish failed: %+v", err)
+		}
+	}()
+}
+
+func (h *hub) PublishContext(ctx context.Context, channels []string, msg interface{}, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+	if !h.beginPublish() {
+		return pubsub.PublishResult{}, pubsub.ErrClosed
+	}
+	defer h.inflight.Done()
+	return h.publishContext(ctx, channels, msg, opts...)
+}
+
+// Registers pending publish unless hub is shutting down.
+func (h *hub) beginPublish() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || h.closing {
+		return false
+	}
+	h.inflight.Add(1)
+	return true
+}
+
+// Logs and publishes message registered as pending publish.
+func (h *hub) publishContext(ctx context.Context, channels []string, msg interface{}, opts ...pubsub.PublishOption) (pubsub.PublishResult, error) {
+	var result pubsub.PublishResult
+	if len(channels) == 0 {
+		return result, nil
+	}
+
+	env := pubsub.NewEnvelope(msg, opts...)
+	pubsub.InjectTrace(ctx, env)
+	retain := pubsub.MakePublishOptions(opts...).Retain
+
+	var logged []string
+	var records [][]byte
+	for _, name := range channels {
+		if pubsub.IsTransient(name) && !retain {
+			// replies and presence events are not kept
+			continue
+		}
+		record := env.ForChannel(name)
+		if retain {
+			record.SetHeader(headerRetain, "true")
+		}
+		data, err := h.encode(record)
+		if err != nil {
+			return result, err
+		}
+		logged = append(logged, name)
+		records = append(records, data)
+	}
+
+	h.RLock()
+	defer h.RUnlock()
+
+	if len(records) > 0 {
+		if err := h.append(logged, records); err != nil {
+			return result, err
+		}
+	}
+
+	return h.inner.PublishContext(ctx, channels, env, opts...)
+}
+
+// Encodes log record.
+func (h *hub) encode(env *pubsub.Envelope) ([]byte, error) {
+	data, err := h.config.Compression.EncodeEnvelope(env)
+	if err != nil {
+		return nil, err
+	}
+	return h.config.Signer.Sign(data)
+}
+
+// Writes records to log according to fsync policy.
+func (h *hub) append(channels []string, records [][]byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return pubsub.ErrClosed
+	}
+	if err := h.log.append(records...); err != nil {
+		return err
+	}
+	for _, name := range channels {
+		h.channels[name] = struct{}{}
+	}
+	if h.config.Fsync == FsyncAlways {
+		return h.log.sync()
+	}
+	return nil
+}
+
+func (h *hub) Subscribe(channels []string) (pubsub.Channel, error) {
+	return h.SubscribeContext(context.Background(), channels)
+}
+
+func (h *hub) SubscribeContext(ctx context.Context, channels []string, opts ...pubsub.SubscribeOption) (pubsub.Channel, error) {
+	return h.inner.SubscribeContext(ctx, channels, opts...)
+}
+
+// History returns up to n last messages of given channel.
+func (h *hub) History(ctx context.Context, channel string, n int) ([]*pubsub.Envelope, error) {
+	return h.history.History(ctx, channel, n)
+}
+
+// HistorySince returns messages of given channel published after message with given id.
+func (h *hub) HistorySince(ctx context.Context, channel string, id string) ([]*pubsub.Envelope, error) {
+	return h.history.HistorySince(ctx, channel, id)
+}
+
+// Retained returns retained message of given channel or nil.
+func (h *hub) Retained(ctx context.Context, channel string) (*pubsub.Envelope, error) {
+	return h.inner.(pubsub.Retainer).Retained(ctx, channel)
+}
+
+// ClearRetained removes retained message of given channel.
+func (h *hub) ClearRetained(ctx context.Context, channel string) error {
+	record := pubsub.NewEnvelope(nil).ForChannel(channel)
+	record.SetHeader(headerRetain, "clear")
+	data, err := h.encode(record)
+	if err != nil {
+		return err
+	}
+
+	h.RLock()
+	defer h.RUnlock()
+
+	if err := h.append(nil, [][]byte{data}); err != nil {
+		return err
+	}
+	return h.inner.(pubsub.Retainer).ClearRetained(ctx, channel)
+}
+
+// Channels returns channels of inner hub.
+func (h *hub) Channels(ctx context.Context) ([]pubsub.ChannelInfo, error) {
+	return h.inner.(pubsub.Inspector).Channels(ctx)
+}
+
+// Subscriptions returns subscriptions of inner hub.
+func (h *hub) Subscriptions(ctx context.Context) ([]pubsub.SubscriptionInfo, error) {
+	return h.inner.(pubsub.Inspector).Subscriptions(ctx)
+}
+
+// Presence returns members of given channel.
+func (h *hub) Presence(ctx context.Context, channel string) ([]pubsub.Member, error) {
+	return h.inner.(pubsub.PresenceReader).Presence(ctx, channel)
+}
+
+func (h *hub) Close() error {
+	if !h.stop() {
+		return nil
+	}
+	err := h.closeLog()
+	h.inner.Close()
+	return err
+}
+
+// Shutdown stops logging publishes and shuts down inner hub gracefully.
+func (h *hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	h.mu.Unlock()
+	err := pubsub.WaitContext(ctx, &h.inflight)
+
+	if !h.stop() {
+		return nil
+	}
+	// wait for publishes holding read lock
+	h.Lock()
+	h.Unlock()
+	if e := pubsub.ShutdownHub(ctx, h.inner); err == nil {
+		err = e
+	}
+	if e := h.closeLog(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Rejects new publishes and stops background jobs, returns false if hub is closed already.
+func (h *hub) stop() bool {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return false
+	}
+	h.closed = true
+	close(h.done)
+	h.mu.Unlock()
+
+	h.wg.Wait()
+	return true
+}
+
+func (h *hub) closeLog() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.log.close()
+}
@@ -0,0 +1,215 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Each record is stored as 4 bytes length, 4 bytes crc32 and data.
+const recordHeaderSize = 8
+
+const segmentExt = ".wal"
+
+var errCorrupted = errors.New("wal: corrupted record")
+
+// Segmented append-only log, not safe for concurrent use.
+type wlog struct {
+	dir         string
+	segmentSize int64
+	segments    []int64 // sequence numbers of segments, last one is active
+	active      *os.File
+	size        int64
+	dirty       bool
+}
+
+func openLog(dir string, segmentSize int64) (*wlog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &wlog{
+		dir:         dir,
+		segmentSize: segmentSize,
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, seq)
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+	return l, nil
+}
+
+func (l *wlog) path(seq int64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// Replay reads all records in order. Torn or corrupted tail of the last segment is truncated.
+func (l *wlog) replay(fn func(data []byte)) error {
+	for i, seq := range l.segments {
+		valid, err := readSegment(l.path(seq), fn)
+		if err == nil {
+			continue
+		}
+		if err != errCorrupted && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if i < len(l.segments)-1 {
+			return fmt.Errorf("%w in segment %d", err, seq)
+		}
+		if err := os.Truncate(l.path(seq), valid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads records of segment file, returns size of valid part.
+func readSegment(path string, fn func(data []byte)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	var valid int64
+	var header [recordHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return valid, nil
+			}
+			return valid, err
+		}
+		n := binary.BigEndian.Uint32(header[:4])
+		if int64(n) > info.Size()-valid-recordHeaderSize {
+			// torn or corrupted length
+			return valid, io.ErrUnexpectedEOF
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return valid, err
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+			return valid, errCorrupted
+		}
+		fn(data)
+		valid += recordHeaderSize + int64(n)
+	}
+}
+
+// Appends records to active segment, starts new segment when it is full.
+func (l *wlog) append(records ...[]byte) error {
+	if l.active == nil || l.size >= l.segmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	var buf []byte
+	for _, data := range records {
+		var header [recordHeaderSize]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+		binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+		buf = append(buf, header[:]...)
+		buf = append(buf, data...)
+	}
+	n, err := l.active.Write(buf)
+	l.size += int64(n)
+	l.dirty = true
+	return err
+}
+
+// Closes active segment and opens the next one.
+func (l *wlog) rotate() error {
+	if err := l.closeActive(); err != nil {
+		return err
+	}
+	var seq int64
+	if len(l.segments) > 0 {
+		seq = l.segments[len(l.segments)-1] + 1
+	}
+	f, err := os.OpenFile(l.path(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, seq)
+	l.active = f
+	l.size = 0
+	return nil
+}
+
+// Rewrites log to given records. Old segments are removed after new one is synced,
+// so crash in between leaves duplicates which are skipped on replay.
+func (l *wlog) rewrite(records [][]byte) error {
+	old := l.segments
+	if err := l.rotate(); err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		if err := l.append(records...); err != nil {
+			return err
+		}
+	}
+	if err := l.sync(); err != nil {
+		return err
+	}
+	for _, seq := range old {
+		if err := os.Remove(l.path(seq)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	l.segments = l.segments[len(old):]
+	return nil
+}
+
+// Flushes written records to disk.
+func (l *wlog) sync() error {
+	if l.active == nil || !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.active.Sync()
+}
+
+func (l *wlog) closeActive() error {
+	if l.active == nil {
+		return nil
+	}
+	if err := l.sync(); err != nil {
+		return err
+	}
+	err := l.active.Close()
+	l.active = nil
+	return err
+}
+
+func (l *wlog) close() error {
+	return l.closeActive()
+}
@@ -0,0 +1,226 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PublishFunc publishes envelope to given channels.
+type PublishFunc func(ctx context.Context, channels []string, env *Envelope, opts ...PublishOption) (PublishResult, error)
+
+// DeliverFunc passes received envelope to subscriber.
+type DeliverFunc func(env *Envelope)
+
+// Middleware intercepts published and delivered messages, both functions are optional.
+type Middleware struct {
+	// Publish wraps publishing, it could modify envelope or reject it returning error without calling next.
+	Publish func(next PublishFunc) PublishFunc
+	// Deliver wraps delivery to subscriber, it could modify envelope or drop it without calling next.
+	Deliver func(next DeliverFunc) DeliverFunc
+}
+
+// Wrap returns hub applying given middlewares to every published and delivered message.
+// The first middleware is the outermost one, it sees published and delivered messages first.
+func Wrap(hub Hub, middlewares ...Middleware) Hub {
+	w := &wrapper{hub: hub}
+
+	w.publish = func(ctx context.Context, channels []string, env *Envelope, opts ...PublishOption) (PublishResult, error) {
+		return hub.PublishContext(ctx, channels, env, opts...)
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if m := middlewares[i].Publish; m != nil {
+			w.publish = m(w.publish)
+		}
+	}
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if m := middlewares[i].Deliver; m != nil {
+			w.deliver = append(w.deliver, m)
+		}
+	}
+
+	return w
+}
+
+// Hub with middlewares.
+type wrapper struct {
+	hub      Hub
+	publish  PublishFunc
+	deliver  []func(next DeliverFunc) DeliverFunc // innermost first
+	mu       sync.Mutex
+	closing  bool           // set by Shutdown, rejects Publish
+	inflight sync.WaitGroup // pending Publish calls, waited by Shutdown
+}
+
+// Unwrap returns underlying hub.
+func (w *wrapper) Unwrap() Hub {
+	return w.hub
+}
+
+func (w *wrapper) Publish(channels []string, msg interface{}) {
+	// registered before returning, so Shutdown waits for it
+	w.mu.Lock()
+	if w.closing {
+		w.mu.Unlock()
+		log.Errorf("publish failed: %+v", ErrClosed)
+		return
+	}
+	w.inflight.Add(1)
+	w.mu.Unlock()
+	go func() {
+		defer w.inflight.Done()
+		_, err := w.PublishContext(context.Background(), channels, msg)
+		if err != nil {
+			log.Errorf("publish failed: %+v", err)
+		}
+	}()
+}
+
+func (w *wrapper) PublishContext(ctx context.Context, channels []string, msg interface{}, opts ...PublishOption) (PublishResult, error) {
+	return w.publish(ctx, channels, NewEnvelope(msg, opts...), opts...)
+}
+
+func (w *wrapper) Subscribe(channels []string) (Channel, error) {
+	return w.SubscribeContext(context.Background(), channels)
+}
+
+func (w *wrapper) SubscribeContext(ctx context.Context, channels []string, opts ...SubscribeOption) (Channel, error) {
+	if len(w.deliver) == 0 {
+		return w.hub.SubscribeContext(ctx, channels, opts...)
+	}
+	// filter sees messages passed through middlewares, e.g. decrypted ones
+	filter := MakeSubscribeOptions(opts...).Filter
+	if filter != nil {
+		opts = append(opts[:len(opts):len(opts)], func(o *SubscribeOptions) {
+			o.Filter = nil
+		})
+	}
+	s, err := w.hub.SubscribeContext(ctx, channels, opts...)
+	if err != nil {
+		return s, err
+	}
+	return w.wrapChannel(s, filter), nil
+}
+
+func (w *wrapper) Close() error {
+	return w.hub.Close()
+}
+
+// Shutdown closes underlying hub gracefully if it is supported.
+func (w *wrapper) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	w.closing = true
+	w.mu.Unlock()
+	if err := WaitContext(ctx, &w.inflight); err != nil {
+		w.hub.Close()
+		return err
+	}
+	return ShutdownHub(ctx, w.hub)
+}
+
+// NewReplyChannel keeps native reply channels of underlying hub.
+func (w *wrapper) NewReplyChannel() string {
+	return NewReplyChannel(w.hub)
+}
+
+// History returns up to n last messages of given channel.
+func (w *wrapper) History(ctx context.Context, channel string, n int) ([]*Envelope, error) {
+	if h, ok := w.hub.(HistoryReader); ok {
+		return h.History(ctx, channel, n)
+	}
+	return nil, ErrUnsupported
+}
+
+// HistorySince returns messages of given channel published after message with given id.
+func (w *wrapper) HistorySince(ctx context.Context, channel string, id string) ([]*Envelope, error) {
+	if h, ok := w.hub.(HistoryReader); ok {
+		return h.HistorySince(ctx, channel, id)
+	}
+	return nil, ErrUnsupported
+}
+
+// Retained returns retained message of given channel or nil.
+func (w *wrapper) Retained(ctx context.Context, channel string) (*Envelope, error) {
+	if r, ok := w.hub.(Retainer); ok {
+		return r.Retained(ctx, channel)
+	}
+	return nil, ErrUnsupported
+}
+
+// ClearRetained removes retained message of given channel.
+func (w *wrapper) ClearRetained(ctx context.Context, channel string) error {
+	if r, ok := w.hub.(Retainer); ok {
+		return r.ClearRetained(ctx, channel)
+	}
+	return ErrUnsupported
+}
+
+// Channels returns channels of underlying hub.
+func (w *wrapper) Channels(ctx context.Context) ([]ChannelInfo, error) {
+	if i, ok := w.hub.(Inspector); ok {
+		return i.Channels(ctx)
+	}
+	return nil, ErrUnsupported
+}
+
+// Subscriptions returns subscriptions of underlying hub.
+func (w *wrapper) Subscriptions(ctx context.Context) ([]SubscriptionInfo, error) {
+	if i, ok := w.hub.(Inspector); ok {
+		return i.Subscriptions(ctx)
+	}
+	return nil, ErrUnsupported
+}
+
+// Presence returns members of given channel.
+func (w *wrapper) Presence(ctx context.Context, channel string) ([]Member, error) {
+	if p, ok := w.hub.(PresenceReader); ok {
+		return p.Presence(ctx, channel)
+	}
+	return nil, ErrUnsupported
+}
+
+// Channel passing received envelopes through delivery middlewares.
+type wrappedChannel struct {
+	Channel
+	inbox *Inbox
+}
+
+func (w *wrapper) wrapChannel(s Channel, filter *FilterExpr) Channel {
+	c := &wrappedChannel{
+		Channel: s,
+		// underlying channel buffers messages already
+		inbox: newInbox(SubscribeOptions{BufferSize: 1, Filter: filter}, nil, true),
+	}
+	deliver := DeliverFunc(func(env *Envelope) {
+		c.inbox.Push(env)
+	})
+	for _, m := range w.deliver {
+		deliver = m(deliver)
+	}
+	go func() {
+		defer c.inbox.Close()
+		for env := range s.ReadEnvelope() {
+			// in-memory hub shares envelope between subscribers
+			deliver(env.ForChannel(env.Channel))
+		}
+	}()
+	return c
+}
+
+// Read returns channel of receiver events.
+func (c *wrappedChannel) Read() <-chan interface{} {
+	return c.inbox.Read()
+}
+
+// ReadEnvelope returns channel of receiver events with metadata.
+func (c *wrappedChannel) ReadEnvelope() <-chan *Envelope {
+	return c.inbox.ReadEnvelope()
+}
+
+// Close removes subscriber from channel.
+func (c *wrappedChannel) Close() error {
+	c.inbox.Stop()
+	return c.Channel.Close()
+}